@@ -0,0 +1,87 @@
+package cafe
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+/*** Definitions ***/
+
+// allowedPath collects every method registered for paths that ServeMux
+// treats as the same route, regardless of wildcard names.
+type allowedPath struct {
+	path    string
+	methods []string
+}
+
+var wildcardName = regexp.MustCompile(`\{[^}.$]*(\.\.\.)?\}`)
+
+/*** Setup ***/
+
+// allowedEntries builds, for every known path, a method-less pattern that
+// answers OPTIONS with the Allow header and any other unregistered method
+// with a 405. Method-specific patterns are more specific, so they still win.
+func (a *App) allowedEntries(routes []route) []muxEntry {
+	paths := map[string]*allowedPath{}
+	order := []string{}
+	for _, rt := range routes {
+		if rt.method == "" {
+			continue
+		}
+		key := shapeOf(a.settings.muxPath(rt.path))
+		ap, ok := paths[key]
+		if !ok {
+			ap = &allowedPath{path: rt.path}
+			paths[key] = ap
+			order = append(order, key)
+		}
+		ap.methods = append(ap.methods, rt.method)
+	}
+
+	entries := []muxEntry{}
+	for _, key := range order {
+		ap := paths[key]
+		entries = append(entries, muxEntry{
+			pattern: a.settings.muxPath(ap.path),
+			handler: setUpMiddlewares(a.allowHandler(allowHeader(ap.methods)), a.middlewares),
+			derived: true,
+		})
+	}
+	return entries
+}
+
+// shapeOf erases wildcard names, so paths ServeMux would consider equal
+// compare equal.
+func shapeOf(path string) string {
+	return wildcardName.ReplaceAllString(path, "{$1}")
+}
+
+func (a *App) allowHandler(allow string) http.HandlerFunc {
+	notAllowed := a.notAllowed
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		notAllowed(w, r)
+	}
+}
+
+// allowHeader builds the Allow header value for a set of registered
+// methods. HEAD is implied by GET, and OPTIONS is always answered.
+func allowHeader(registered []string) string {
+	allowed := slices.Clone(registered)
+	if slices.Contains(allowed, http.MethodGet) {
+		allowed = append(allowed, http.MethodHead)
+	}
+	allowed = append(allowed, http.MethodOptions)
+	slices.Sort(allowed)
+	return strings.Join(slices.Compact(allowed), ", ")
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, NewProblem(http.StatusMethodNotAllowed, ""))
+}
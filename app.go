@@ -1,90 +1,474 @@
 package cafe
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
 /*** Definitions ***/
 
 type App struct {
-	server      http.Server
-	handler     *http.ServeMux
-	routers     []mountedRouter
-	routes      []route
-	middlewares []middleware
+	mu            sync.Mutex
+	server        *http.Server
+	handler       *routeSwitch
+	routers       []mountedRouter
+	routes        []route
+	hosts         []hostRouter
+	middlewares   []Middleware
+	shutdownHooks []ShutdownHook
+	errs          []error
+	notFound      http.HandlerFunc
+	notAllowed    http.HandlerFunc
+	errorHandler  ErrorHandlerFunc
+	settings      settings
 }
 
-type middleware func(next http.HandlerFunc) http.HandlerFunc
+type settings struct {
+	shutdownTimeout    time.Duration
+	invalidParamStatus int
+	trailingSlash      TrailingSlash
+	caseInsensitive    bool
+	newMatcher         func() Matcher
+}
+
+// Middleware wraps a handler. Middlewares written against the standard
+// library signature, func(http.Handler) http.Handler, can be adapted with
+// FromHandler.
+type Middleware func(next http.HandlerFunc) http.HandlerFunc
+
+// ShutdownHook runs once the server has stopped accepting connections and
+// in-flight requests have drained (or the shutdown deadline has passed).
+type ShutdownHook func(ctx context.Context) error
+
+// Option configures an App at construction time.
+type Option func(s *settings)
+
+const defaultShutdownTimeout = 10 * time.Second
 
 /*** Init ***/
 
-func NewServer() App {
+func NewServer(opts ...Option) App {
+	s := settings{
+		shutdownTimeout:    defaultShutdownTimeout,
+		invalidParamStatus: http.StatusBadRequest,
+		newMatcher:         newServeMux,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	errs := []error{}
+	if s.invalidParamStatus != http.StatusBadRequest && s.invalidParamStatus != http.StatusNotFound {
+		errs = append(errs, &RouteError{
+			Pattern: fmt.Sprintf("WithInvalidParamStatus(%d)", s.invalidParamStatus),
+			Source:  callerSite(1),
+			Reason:  "needs http.StatusBadRequest or http.StatusNotFound",
+		})
+		s.invalidParamStatus = http.StatusBadRequest
+	}
 	return App{
-		handler:     http.NewServeMux(),
-		routers:     []mountedRouter{},
-		routes:      []route{},
-		middlewares: []middleware{},
+		handler:       &routeSwitch{},
+		routers:       []mountedRouter{},
+		routes:        []route{},
+		middlewares:   []Middleware{},
+		shutdownHooks: []ShutdownHook{},
+		errs:          errs,
+		notFound:      problemHandler(http.StatusNotFound),
+		notAllowed:    methodNotAllowed,
+		settings:      s,
+	}
+}
+
+// WithShutdownTimeout bounds how long ListenContext waits for in-flight
+// requests to finish once its context is cancelled.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(s *settings) {
+		s.shutdownTimeout = d
+	}
+}
+
+// WithInvalidParamStatus chooses how requests whose path values break a
+// route constraint, such as {id:int}, are answered: http.StatusBadRequest
+// (the default) or http.StatusNotFound, which uses the not-found handler in
+// scope for the route. Validate rejects any other status.
+func WithInvalidParamStatus(status int) Option {
+	return func(s *settings) {
+		s.invalidParamStatus = status
 	}
 }
 
 /*** Aggregation ***/
 
 func (a *App) UseRouter(path string, ro *Router) {
-	for _, mr := range a.routers {
-		if mr.path == path {
-			return
-		}
+	a.mountRouter(mountedRouter{path: path, router: ro, source: callerSite(1)})
+}
+
+func (a *App) mountRouter(mr mountedRouter) {
+	mr.unwatch = mr.router.watch(a.routerChanged)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var err error
+	a.routers, err = addRouter(a.routers, mr)
+	a.errs = appendError(a.errs, err)
+	if err != nil {
+		mr.unwatch()
+	}
+	a.reload()
+}
+
+func (a *App) Use(mws ...Middleware) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.middlewares = append(a.middlewares, mws...)
+	a.reload()
+}
+
+func (a *App) OnShutdown(hook ShutdownHook) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.shutdownHooks = append(a.shutdownHooks, hook)
+}
+
+// NotFound replaces the handler used when no route matches. Like every
+// route, it runs behind the global middlewares.
+func (a *App) NotFound(handler http.HandlerFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.notFound = handler
+	a.reload()
+}
+
+// MethodNotAllowed replaces the handler used when a path exists but not for
+// the request method. The Allow header is already set when it runs.
+func (a *App) MethodNotAllowed(handler http.HandlerFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.notAllowed = handler
+	a.reload()
+}
+
+func (a *App) addRoute(rt route, mws []Middleware) *Route {
+	if rt.meta == nil {
+		rt.meta = &Route{update: a.updateRoute}
+	}
+	if rt.funcName == "" {
+		rt.funcName = handlerName(rt.handler)
 	}
-	a.routers = append(a.routers, mountedRouter{path: path, router: ro})
+	rt.handler = setUpMiddlewares(rt.handler, mws)
+	rt.middlewares = len(mws)
+	rt.source = callerSite(2)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var err error
+	a.routes, err = addRoute(a.routes, rt)
+	a.errs = appendError(a.errs, err)
+	a.reload()
+	return rt.meta
 }
 
-func (a *App) Use(mw middleware) {
-	a.middlewares = append(a.middlewares, mw)
+// updateRoute changes the metadata of an app route under the app lock, and
+// rebuilds the route table of a running app so names resolve at once.
+func (a *App) updateRoute(change func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	change()
+	a.reload()
 }
 
-func addRoute(routes []route, path, method string, handler http.HandlerFunc) []route {
+func addRoute(routes []route, rt route) ([]route, error) {
+	path, constraints, err := parseConstraints(rt.path)
+	if err != nil {
+		return routes, &RouteError{Pattern: rt.path, Source: rt.source, Reason: "is invalid: " + err.Error()}
+	}
+	rt.path, rt.constraints = path, constraints
+
 	for _, r := range routes {
-		if r.path == path && r.method == method {
-			return routes
+		if r.path == rt.path && r.method == rt.method {
+			return routes, &RouteError{
+				Pattern:     rt.pattern(settings{}),
+				Source:      rt.source,
+				Reason:      "duplicates",
+				Other:       r.pattern(settings{}),
+				OtherSource: r.source,
+			}
 		}
 	}
-	return append(routes, route{
-		path:    path,
-		method:  method,
-		handler: handler,
-	})
+	return append(routes, rt), nil
+}
+
+func addRouter(routers []mountedRouter, mr mountedRouter) ([]mountedRouter, error) {
+	path, constraints, err := parseConstraints(mr.path)
+	if err != nil {
+		return routers, &RouteError{Pattern: "router " + mr.path, Source: mr.source, Reason: "is invalid: " + err.Error()}
+	}
+	mr.path, mr.constraints = path, constraints
+
+	// Routers without a prefix, like unprefixed groups, only share
+	// middlewares; their routes are still checked for duplicates.
+	for _, m := range routers {
+		if m.path == mr.path && mr.path != "" {
+			return routers, &RouteError{
+				Pattern:     "router " + mr.path,
+				Source:      mr.source,
+				Reason:      "duplicates",
+				Other:       "router " + m.path,
+				OtherSource: m.source,
+			}
+		}
+	}
+	return append(routers, mr), nil
 }
 
 /*** Setup ***/
 
 func (a *App) Listen(addr string) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	srv := a.newServer(addr)
+	return srv.ListenAndServe()
+}
+
+// ListenContext serves on addr until ctx is cancelled, then stops accepting
+// new connections, drains in-flight requests for up to the configured
+// shutdown timeout and runs the registered shutdown hooks.
+func (a *App) ListenContext(ctx context.Context, addr string) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return a.serveContext(ctx, ln)
+}
+
+func (a *App) serveContext(ctx context.Context, ln net.Listener) error {
+	srv := a.newServer(ln.Addr().String())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.settings.shutdownTimeout)
+	defer cancel()
+	err := a.Shutdown(sctx)
+	if serr := <-errc; !errors.Is(serr, http.ErrServerClosed) {
+		err = errors.Join(err, serr)
+	}
+	return err
+}
+
+// Shutdown gracefully stops the server started by Listen or ListenContext,
+// waiting for in-flight requests until ctx expires, and then runs every
+// shutdown hook in registration order. After Shutdown, Listen and
+// ListenContext return http.ErrServerClosed without serving, even when they
+// had not started yet.
+func (a *App) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	srv := a.httpServer()
+	hooks := a.shutdownHooks
+	a.shutdownHooks = []ShutdownHook{}
+	a.mu.Unlock()
+
+	var errs []error
+	if err := srv.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a *App) newServer(addr string) *http.Server {
 	a.setUpRouters()
-	a.server = http.Server{
-		Addr:    addr,
-		Handler: a.handler,
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	srv := a.httpServer()
+	srv.Addr = addr
+	return srv
+}
+
+// httpServer returns the server of the app, created on first use by either
+// Listen or Shutdown, so a Shutdown that comes first still stops it. a.mu
+// must be held.
+func (a *App) httpServer() *http.Server {
+	if a.server == nil {
+		a.server = &http.Server{Handler: a}
+	}
+	return a.server
+}
+
+// ServeHTTP makes App usable as a plain http.Handler, e.g. with
+// httptest.NewServer or mounted under another mux. Routes are set up on the
+// first request; a request is served entirely by the route table that was
+// current when it arrived.
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := a.setUpRouters()
+	if r.Method != http.MethodConnect {
+		if clean := a.settings.cleanPath(r.URL.Path); clean != r.URL.Path {
+			t.nonCanonical(clean)(w, r)
+			return
+		}
+	}
+	t.dispatch(w, r)
+}
+
+// muxEntries lists every pattern registered on the Matcher of host ("" for
+// the default one): the routes themselves, then the derived OPTIONS/405,
+// trailing-slash and not-found patterns, spread per method below SPA
+// routes.
+func (a *App) muxEntries(host string) []muxEntry {
+	routes := routesFor(a.getRoutes(), host)
+	entries := []muxEntry{}
+	for _, rt := range routes {
+		entries = append(entries, muxEntry{
+			pattern: rt.pattern(a.settings),
+			handler: rt.handler,
+			source:  rt.source,
+		})
+	}
+	entries = append(entries, a.allowedEntries(routes)...)
+	entries = append(entries, a.toggledEntries(entries)...)
+	entries = append(entries, a.notFoundEntries(host)...)
+	return spaEntries(routes, entries)
+}
+
+// notFoundEntries lists the patterns answering the requests of host that no
+// route matches: the not-found handlers of the app and of every router.
+func (a *App) notFoundEntries(host string) []muxEntry {
+	entries := []muxEntry{}
+	for _, nf := range routesFor(a.getNotFound(), host) {
+		entries = append(entries, muxEntry{
+			pattern: nf.path,
+			handler: nf.handler,
+			derived: true,
+		})
+	}
+	return entries
+}
+
+// getRoutes flattens the app routes, every mounted router and every host
+// router into full paths, with the global middlewares applied.
+func (a *App) getRoutes() []route {
+	mountedRoutes := a.flatten(a.routes, a.routers)
+	for _, h := range a.hosts {
+		for _, rt := range a.flatten(nil, []mountedRouter{h.mount}) {
+			rt.host = h.host
+			mountedRoutes = append(mountedRoutes, rt)
+		}
 	}
-	return a.server.ListenAndServe()
+	return mountedRoutes
 }
 
-func (a *App) setUpRouters() {
-	for _, r := range a.routes {
-		h := setUpMiddlewares(r.handler, a.middlewares)
-		a.handle(r.path, r.method, h)
+func (a *App) flatten(routes []route, routers []mountedRouter) []route {
+	mountedRoutes := []route{}
+	for _, rt := range routes {
+		mountedRoutes = append(mountedRoutes, rt.withMeta())
+	}
+	for _, mr := range routers {
+		for _, rt := range mr.router.getRoutes() {
+			rt.path = mr.path + rt.path
+			if mr.path != "" {
+				rt.mounts = append([]string{mr.path}, rt.mounts...)
+			}
+			rt.constraints = append(slices.Clone(mr.constraints), rt.constraints...)
+			mountedRoutes = append(mountedRoutes, rt)
+		}
+	}
+	for i, rt := range mountedRoutes {
+		if a.settings.caseInsensitive {
+			mountedRoutes[i].path = lowerLiterals(rt.path)
+		}
+		if len(rt.constraints) > 0 {
+			rt.handler = checkConstraints(rt.handler, a.invalidParam(rt), rt.constraints)
+		}
+		if a.errorHandler != nil {
+			rt.handler = withErrorHandler(rt.handler, a.errorHandler)
+		}
+		mountedRoutes[i].handler = setUpMiddlewares(rt.handler, a.middlewares)
+		mountedRoutes[i].middlewares += len(a.middlewares)
+		if a.settings.caseInsensitive {
+			mountedRoutes[i].handler = a.unfoldCase(mountedRoutes[i].handler, mountedRoutes[i].path)
+		}
 	}
+	return mountedRoutes
+}
 
-	for _, mr := range a.routers {
-		routes := mr.router.getRoutes()
-		for _, r := range routes {
-			path := mr.path + r.path
-			h := setUpMiddlewares(r.handler, a.middlewares)
-			a.handle(path, r.method, h)
+// invalidParam is the handler for requests whose path values break one of
+// rt's constraints: a 400, or the not-found handler in scope for rt,
+// depending on the settings.
+func (a *App) invalidParam(rt route) http.HandlerFunc {
+	if a.settings.invalidParamStatus != http.StatusNotFound {
+		return invalidParam
+	}
+	if rt.notFound != nil {
+		return rt.notFound
+	}
+	return a.notFound
+}
+
+// getNotFound returns the app-wide not-found handler plus one for every
+// mounted router, keyed by the subtree path they cover. Host routers are
+// mounted at their root, so they cover the whole host.
+func (a *App) getNotFound() []route {
+	handlers := []route{{
+		path:    "/",
+		handler: setUpMiddlewares(a.notFound, a.middlewares),
+	}}
+	handlers = append(handlers, a.flattenNotFound(a.routers)...)
+	for _, h := range a.hosts {
+		for _, nf := range a.flattenNotFound([]mountedRouter{h.mount}) {
+			nf.host = h.host
+			handlers = append(handlers, nf)
 		}
 	}
+	return handlers
 }
 
-func setUpMiddlewares(f http.HandlerFunc, mws []middleware) http.HandlerFunc {
+func (a *App) flattenNotFound(routers []mountedRouter) []route {
+	handlers := []route{}
+	for _, mr := range routers {
+		for _, nf := range mr.router.getNotFound(a.notFound) {
+			nf.path = mr.path + nf.path
+			nf.handler = setUpMiddlewares(nf.handler, a.middlewares)
+			handlers = append(handlers, nf)
+		}
+	}
+	return handlers
+}
+
+// routesFor keeps the routes served on host.
+func routesFor(routes []route, host string) []route {
+	hostRoutes := []route{}
+	for _, rt := range routes {
+		if rt.host == host {
+			hostRoutes = append(hostRoutes, rt)
+		}
+	}
+	return hostRoutes
+}
+
+func setUpMiddlewares(f http.HandlerFunc, mws []Middleware) http.HandlerFunc {
 	if len(mws) == 0 {
 		return f
 	}
@@ -95,29 +479,88 @@ func setUpMiddlewares(f http.HandlerFunc, mws []middleware) http.HandlerFunc {
 	return f
 }
 
-func (a *App) handle(path, method string, handler http.HandlerFunc) {
-	patt := fmt.Sprintf("%s %s", method, path)
-	if !strings.HasSuffix(patt, "/") {
-		patt += "/"
+// pattern is the ServeMux pattern rt is served under. Prefix routes match
+// their whole subtree; every other route matches its path exactly, in the
+// form the trailing-slash policy in s makes canonical.
+func (rt route) pattern(s settings) string {
+	path := s.muxPath(rt.path)
+	if rt.prefix {
+		path = strings.TrimSuffix(rt.path, "/") + "/"
 	}
-	patt += "{$}"
-	a.handler.Handle(patt, handler)
+	if rt.method == "" {
+		return path
+	}
+	return fmt.Sprintf("%s %s", rt.method, path)
+}
+
+// muxPath turns a route path into the exact-match ServeMux path it is
+// served under. Paths ending in a catch-all {name...} wildcard already match
+// everything below them and are kept as they are.
+func muxPath(path string) string {
+	if strings.HasSuffix(path, "...}") || strings.HasSuffix(path, "{$}") {
+		return path
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path + "{$}"
 }
 
 /*** Basic HTTP Methods ***/
 
-func (a *App) Get(path string, handler http.HandlerFunc) {
-	a.routes = addRoute(a.routes, path, "GET", handler)
+func (a *App) Get(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: path, method: http.MethodGet, handler: handler}, mws)
+}
+
+func (a *App) Post(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: path, method: http.MethodPost, handler: handler}, mws)
+}
+
+func (a *App) Put(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: path, method: http.MethodPut, handler: handler}, mws)
 }
 
-func (a *App) Post(path string, handler http.HandlerFunc) {
-	a.routes = addRoute(a.routes, path, "POST", handler)
+func (a *App) Delete(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: path, method: http.MethodDelete, handler: handler}, mws)
 }
 
-func (a *App) Put(path string, handler http.HandlerFunc) {
-	a.routes = addRoute(a.routes, path, "PUT", handler)
+func (a *App) Patch(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: path, method: http.MethodPatch, handler: handler}, mws)
 }
 
-func (a *App) Delete(path string, handler http.HandlerFunc) {
-	a.routes = addRoute(a.routes, path, "DELETE", handler)
+func (a *App) Head(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: path, method: http.MethodHead, handler: handler}, mws)
+}
+
+func (a *App) Options(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: path, method: http.MethodOptions, handler: handler}, mws)
+}
+
+func (a *App) Connect(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: path, method: http.MethodConnect, handler: handler}, mws)
+}
+
+func (a *App) Trace(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: path, method: http.MethodTrace, handler: handler}, mws)
+}
+
+/*** Generic HTTP Methods ***/
+
+func (a *App) Handle(method, path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: path, method: method, handler: handler}, mws)
+}
+
+// Prefix registers handler for path and everything below it. The rest of
+// the path is available as r.PathValue(cafe.RestParam).
+func (a *App) Prefix(method, path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return a.addRoute(route{path: prefixPath(path), method: method, handler: handler}, mws)
+}
+
+// Any registers handler for every standard HTTP method on path.
+func (a *App) Any(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	meta := &Route{update: a.updateRoute}
+	for _, method := range methods {
+		a.addRoute(route{path: path, method: method, handler: handler, meta: meta}, mws)
+	}
+	return meta
 }
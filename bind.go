@@ -0,0 +1,618 @@
+package cafe
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/*** Definitions ***/
+
+// FieldError describes a request field that could not be bound or failed
+// validation.
+type FieldError struct {
+	// Field is the name of the field in its source, e.g. the query parameter
+	// or the JSON member, with nested members separated by dots.
+	Field string `json:"field"`
+	// In is the source of the field: path, query, header, form or body.
+	In string `json:"in"`
+	// Rule is the validation rule that failed, or "type" when the value
+	// could not be converted to the field type.
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field error of a request. The default error
+// handler renders it as a 400 problem with the fields as "errors".
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// bindField is a struct field Bind fills or validates.
+type bindField struct {
+	index []int
+	name  string
+	in    string
+	rules []bindRule
+}
+
+type bindRule struct {
+	name string
+	arg  string
+	num  float64
+}
+
+// maxMultipartMemory is how much of a multipart body is kept in memory;
+// larger files are stored in temporary files.
+const maxMultipartMemory = 32 << 20
+
+var (
+	bindFieldsCache sync.Map
+	textUnmarshaler = reflect.TypeFor[encoding.TextUnmarshaler]()
+	jsonUnmarshaler = reflect.TypeFor[json.Unmarshaler]()
+	fileHeaderType  = reflect.TypeFor[*multipart.FileHeader]()
+	durationType    = reflect.TypeFor[time.Duration]()
+)
+
+func (e *ValidationError) Error() string {
+	msgs := []string{}
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+" "+f.Message)
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+/*** Binding ***/
+
+// Bind fills the struct dst points to from r and validates it.
+//
+// The body is decoded according to its Content-Type: JSON as encoding/json
+// does, except that fields read from elsewhere are left out, and
+// url-encoded or multipart forms into the fields tagged form:"name" (or
+// named by their json tag or Go name). Fields tagged path:"name",
+// query:"name" or header:"Name" are read from the path values, the query
+// string and the request headers. Multipart files bind to
+// *multipart.FileHeader or []*multipart.FileHeader fields.
+//
+// Fields are then checked against their validate tag, a comma-separated
+// list of rules: required, min=N, max=N and len=N (the value of numbers, the
+// length of strings, slices and maps), email, uuid and oneof=a b c. Rules
+// other than required are skipped for empty strings, slices and maps and
+// for nil pointers, but numbers are always checked.
+//
+// Every conversion and validation failure is reported together in a
+// *ValidationError. A malformed body is an *HTTPError with status 400 and an
+// unsupported Content-Type one with status 415.
+func Bind(r *http.Request, dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cafe: Bind needs a non-nil pointer to a struct, got %T", dst)
+	}
+	v = v.Elem()
+	fields, err := bindFieldsOf(v.Type())
+	if err != nil {
+		return err
+	}
+
+	errs := []FieldError{}
+	form, err := bindBody(r, dst, &errs)
+	if err != nil {
+		return err
+	}
+	failed := map[string]bool{}
+	for _, f := range errs {
+		failed[f.Field] = true
+	}
+
+	query := r.URL.Query()
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		var values []string
+		switch f.in {
+		case "path":
+			if value := r.PathValue(f.name); value != "" {
+				values = []string{value}
+			}
+		case "query":
+			values = query[f.name]
+		case "header":
+			values = r.Header.Values(f.name)
+		case "form", "body":
+			if form == nil {
+				continue
+			}
+			f.in = "form"
+			if fv.Type() == fileHeaderType || fv.Type() == reflect.SliceOf(fileHeaderType) {
+				bindFiles(fv, form.File[f.name])
+				continue
+			}
+			values = form.Value[f.name]
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := setField(fv, values); err != nil {
+			errs = append(errs, FieldError{Field: f.name, In: f.in, Rule: "type", Message: err.Error()})
+			failed[f.name] = true
+		}
+	}
+
+	for _, f := range fields {
+		if failed[f.name] {
+			continue
+		}
+		if form != nil && f.in == "body" {
+			f.in = "form"
+		}
+		errs = append(errs, f.check(v.FieldByIndex(f.index))...)
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+// bindBody decodes a JSON body into dst, recording type mismatches in errs,
+// or parses a form body and returns it.
+func bindBody(r *http.Request, dst any, errs *[]FieldError) (*multipart.Form, error) {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, &HTTPError{Status: http.StatusUnsupportedMediaType, Message: "missing or invalid Content-Type", Err: err}
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var data json.RawMessage
+		err := json.NewDecoder(r.Body).Decode(&data)
+		switch {
+		case errors.Is(err, io.EOF):
+		case err != nil:
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, err
+			}
+			return nil, &HTTPError{Status: http.StatusBadRequest, Message: "malformed JSON body: " + err.Error(), Err: err}
+		default:
+			decodeJSON(data, reflect.ValueOf(dst).Elem(), "", errs)
+		}
+		return nil, nil
+	case mediaType == "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return nil, formError(err)
+		}
+		return &multipart.Form{Value: r.PostForm}, nil
+	case mediaType == "multipart/form-data":
+		if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+			return nil, formError(err)
+		}
+		return r.MultipartForm, nil
+	}
+	return nil, &HTTPError{Status: http.StatusUnsupportedMediaType, Message: "unsupported Content-Type " + mediaType}
+}
+
+// decodeJSON decodes data into v, recording in errs every value that doesn't
+// fit its field. Structs are decoded member by member, so fields read from
+// the path, query or headers are never set from the body, and a mismatch
+// doesn't hide the ones after it.
+func decodeJSON(data json.RawMessage, v reflect.Value, field string, errs *[]FieldError) {
+	if v.Kind() == reflect.Pointer && isJSONObject(v.Type().Elem()) && string(data) != "null" {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if !isJSONObject(v.Type()) {
+		err := json.Unmarshal(data, v.Addr().Interface())
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &typeErr):
+			*errs = append(*errs, FieldError{Field: joinField(field, typeErr.Field), In: "body", Rule: "type", Message: "must be " + typeName(typeErr.Type)})
+		case err != nil:
+			*errs = append(*errs, FieldError{Field: field, In: "body", Rule: "type", Message: "is invalid: " + err.Error()})
+		}
+		return
+	}
+
+	members := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		*errs = append(*errs, FieldError{Field: field, In: "body", Rule: "type", Message: "must be an object"})
+		return
+	}
+	decodeMembers(members, v, field, errs)
+}
+
+// decodeMembers decodes the members of a JSON object into the fields of
+// struct v, flattening embedded structs as encoding/json does, and reports
+// whether any member matched.
+func decodeMembers(members map[string]json.RawMessage, v reflect.Value, prefix string, errs *[]FieldError) bool {
+	matched := false
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		name, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if !sf.IsExported() || name == "-" || isSourceField(sf) {
+			continue
+		}
+		fv := v.Field(i)
+		if sf.Anonymous && name == "" {
+			if isJSONObject(sf.Type) {
+				matched = decodeMembers(members, fv, prefix, errs) || matched
+				continue
+			}
+			if sf.Type.Kind() == reflect.Pointer && isJSONObject(sf.Type.Elem()) {
+				embedded := fv
+				if fv.IsNil() {
+					embedded = reflect.New(sf.Type.Elem())
+				}
+				if decodeMembers(members, embedded.Elem(), prefix, errs) {
+					fv.Set(embedded)
+					matched = true
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		data, ok := memberOf(members, name)
+		if !ok {
+			continue
+		}
+		matched = true
+		if slices.Contains(strings.Split(opts, ","), "string") {
+			var quoted string
+			if json.Unmarshal(data, &quoted) == nil {
+				data = json.RawMessage(quoted)
+			}
+		}
+		decodeJSON(data, fv, joinField(prefix, name), errs)
+	}
+	return matched
+}
+
+// memberOf returns the member named name, preferring an exact match to a
+// case-insensitive one as encoding/json does.
+func memberOf(members map[string]json.RawMessage, name string) (json.RawMessage, bool) {
+	if data, ok := members[name]; ok {
+		return data, true
+	}
+	for key, data := range members {
+		if strings.EqualFold(key, name) {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// isSourceField reports whether sf is read from the path, query or headers
+// instead of the body.
+func isSourceField(sf reflect.StructField) bool {
+	for _, in := range []string{"path", "query", "header"} {
+		if _, ok := sf.Tag.Lookup(in); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isJSONObject reports whether t is a struct decodeJSON walks member by
+// member rather than leaving to its own unmarshaling.
+func isJSONObject(t reflect.Type) bool {
+	return isNestedStruct(t) && !reflect.PointerTo(t).Implements(jsonUnmarshaler)
+}
+
+func joinField(prefix, name string) string {
+	if prefix == "" || name == "" {
+		return prefix + name
+	}
+	return prefix + "." + name
+}
+
+func formError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+	return &HTTPError{Status: http.StatusBadRequest, Message: "malformed form body", Err: err}
+}
+
+func bindFiles(fv reflect.Value, files []*multipart.FileHeader) {
+	if len(files) == 0 {
+		return
+	}
+	if fv.Kind() == reflect.Slice {
+		fv.Set(reflect.ValueOf(files))
+		return
+	}
+	fv.Set(reflect.ValueOf(files[0]))
+}
+
+// setField converts values into fv: the first one, or all of them for a
+// slice.
+func setField(fv reflect.Value, values []string) error {
+	if fv.Kind() == reflect.Pointer && fv.Type().Elem().Kind() != reflect.Slice {
+		elem := reflect.New(fv.Type().Elem())
+		if err := setField(elem.Elem(), values); err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	}
+	if fv.Addr().Type().Implements(textUnmarshaler) {
+		if err := fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(values[0])); err != nil {
+			return fmt.Errorf("is invalid: %v", err)
+		}
+		return nil
+	}
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setField(slice.Index(i), []string{value}); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	return setScalar(fv, values[0])
+}
+
+func setScalar(fv reflect.Value, value string) error {
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New("must be a duration")
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return errors.New("must be a non-negative integer")
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		fv.SetFloat(n)
+	default:
+		return fmt.Errorf("cannot be bound to %s", fv.Type())
+	}
+	return nil
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	}
+	return "a " + t.String()
+}
+
+/*** Fields ***/
+
+// bindFieldsOf lists the fields of struct type t, cached per type.
+func bindFieldsOf(t reflect.Type) ([]bindField, error) {
+	if cached, ok := bindFieldsCache.Load(t); ok {
+		return cached.([]bindField), nil
+	}
+	fields, err := collectFields(t, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	bindFieldsCache.Store(t, fields)
+	return fields, nil
+}
+
+func collectFields(t reflect.Type, index []int, prefix string) ([]bindField, error) {
+	fields := []bindField{}
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		f := bindField{index: append(append([]int{}, index...), i), in: "body"}
+		for _, in := range []string{"path", "query", "header", "form"} {
+			if name, ok := sf.Tag.Lookup(in); ok {
+				f.name, f.in = name, in
+				break
+			}
+		}
+		if f.in == "form" {
+			f.in = "body"
+		}
+		if f.name == "" {
+			jsonName, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if jsonName == "-" {
+				continue
+			}
+			f.name = jsonName
+			if f.name == "" && !sf.Anonymous {
+				f.name = sf.Name
+			}
+			f.name = strings.TrimPrefix(prefix+"."+f.name, ".")
+		}
+
+		if f.in == "body" && isNestedStruct(sf.Type) {
+			nestedPrefix := f.name
+			if sf.Anonymous && sf.Tag.Get("json") == "" {
+				nestedPrefix = prefix
+			}
+			nested, err := collectFields(sf.Type, f.index, nestedPrefix)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nested...)
+		}
+
+		rules, err := parseRules(sf.Tag.Get("validate"))
+		if err != nil {
+			return nil, fmt.Errorf("cafe: field %s.%s: %w", t, sf.Name, err)
+		}
+		f.rules = rules
+		if len(rules) > 0 || f.in != "body" || !isNestedStruct(sf.Type) {
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+func isNestedStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(textUnmarshaler) && t != reflect.TypeFor[time.Time]()
+}
+
+func parseRules(tag string) ([]bindRule, error) {
+	rules := []bindRule{}
+	if tag == "" {
+		return rules, nil
+	}
+	for _, spec := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(spec), "=")
+		rule := bindRule{name: name, arg: arg}
+		switch name {
+		case "required", "email", "uuid":
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s needs a number, got %q", name, arg)
+			}
+			rule.num = n
+		case "oneof":
+			if arg == "" {
+				return nil, errors.New("rule oneof needs values")
+			}
+		default:
+			return nil, fmt.Errorf("unknown validation rule %q", name)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+/*** Validation ***/
+
+// check validates v against the field rules.
+func (f bindField) check(v reflect.Value) []FieldError {
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	empty := v.IsZero()
+	_, unit := measure(v)
+	optional := empty && (unit != "" || v.Kind() == reflect.Pointer)
+
+	errs := []FieldError{}
+	for _, rule := range f.rules {
+		if rule.name != "required" && optional {
+			continue
+		}
+		if msg := rule.check(v, empty); msg != "" {
+			errs = append(errs, FieldError{Field: f.name, In: f.in, Rule: rule.name, Message: msg})
+		}
+	}
+	return errs
+}
+
+// check returns why v breaks the rule, or "" when it doesn't.
+func (rule bindRule) check(v reflect.Value, empty bool) string {
+	switch rule.name {
+	case "required":
+		if empty {
+			return "is required"
+		}
+	case "min", "max", "len":
+		n, unit := measure(v)
+		if unit == "?" {
+			return ""
+		}
+		switch {
+		case rule.name == "min" && n < rule.num:
+			return "must be at least " + rule.arg + unit
+		case rule.name == "max" && n > rule.num:
+			return "must be at most " + rule.arg + unit
+		case rule.name == "len" && n != rule.num:
+			return "must be exactly " + rule.arg + unit
+		}
+	case "email":
+		s := fmt.Sprint(v.Interface())
+		if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+			return "must be a valid email address"
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(fmt.Sprint(v.Interface())) {
+			return "must be a UUID"
+		}
+	case "oneof":
+		options := strings.Fields(rule.arg)
+		s := fmt.Sprint(v.Interface())
+		for _, o := range options {
+			if o == s {
+				return ""
+			}
+		}
+		return "must be one of: " + strings.Join(options, ", ")
+	}
+	return ""
+}
+
+// measure returns what min, max and len compare for v: the value of a
+// number, or the length of anything else, with the unit to report.
+func measure(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), " items"
+	}
+	return 0, "?"
+}
@@ -2,11 +2,18 @@ package cafe
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewServer(t *testing.T) {
@@ -48,7 +55,7 @@ func TestApp_Get(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/test/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK, got %d", rr.Code)
@@ -70,7 +77,7 @@ func TestApp_Post(t *testing.T) {
 
 	req := httptest.NewRequest("POST", "/test/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusCreated {
 		t.Errorf("Expected status Created, got %d", rr.Code)
@@ -92,7 +99,7 @@ func TestApp_Put(t *testing.T) {
 
 	req := httptest.NewRequest("PUT", "/test/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusAccepted {
 		t.Errorf("Expected status Accepted, got %d", rr.Code)
@@ -114,7 +121,7 @@ func TestApp_Delete(t *testing.T) {
 
 	req := httptest.NewRequest("DELETE", "/test/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusNoContent {
 		t.Errorf("Expected status No Content, got %d", rr.Code)
@@ -145,7 +152,7 @@ func TestApp_UseRouter(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/api/sub/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK, got %d", rr.Code)
@@ -236,7 +243,7 @@ func TestRouter_UseRouter(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/main/sub/nested/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK, got %d", rr.Code)
@@ -255,8 +262,14 @@ func TestAddRoute_Duplicate(t *testing.T) {
 	handler1 := func(w http.ResponseWriter, r *http.Request) {}
 	handler2 := func(w http.ResponseWriter, r *http.Request) {}
 
-	routes = addRoute(routes, "/test", "GET", handler1)
-	routes = addRoute(routes, "/test", "GET", handler2) // Should not add a duplicate
+	routes, err := addRoute(routes, route{path: "/test", method: "GET", handler: handler1})
+	if err != nil {
+		t.Errorf("Expected no error for first route, got %v", err)
+	}
+	routes, err = addRoute(routes, route{path: "/test", method: "GET", handler: handler2}) // Should not add a duplicate
+	if err == nil {
+		t.Error("Expected an error for duplicate route, got nil")
+	}
 
 	if len(routes) != 1 {
 		t.Errorf("Expected 1 route after adding a duplicate, got %d", len(routes))
@@ -291,7 +304,7 @@ func TestSetUpRouters_Order(t *testing.T) {
 	// Test root route
 	reqRoot := httptest.NewRequest("GET", "/root/", nil)
 	rrRoot := httptest.NewRecorder()
-	app.handler.ServeHTTP(rrRoot, reqRoot)
+	app.ServeHTTP(rrRoot, reqRoot)
 	if rrRoot.Code != http.StatusOK {
 		t.Errorf("Expected status OK for root, got %d", rrRoot.Code)
 	}
@@ -299,7 +312,7 @@ func TestSetUpRouters_Order(t *testing.T) {
 	// Test api1/sub1 route
 	reqSub1 := httptest.NewRequest("GET", "/api1/sub1/", nil)
 	rrSub1 := httptest.NewRecorder()
-	app.handler.ServeHTTP(rrSub1, reqSub1)
+	app.ServeHTTP(rrSub1, reqSub1)
 	if rrSub1.Code != http.StatusOK {
 		t.Errorf("Expected status OK for /api1/sub1, got %d", rrSub1.Code)
 	}
@@ -307,7 +320,7 @@ func TestSetUpRouters_Order(t *testing.T) {
 	// Test api2/sub2 route
 	reqSub2 := httptest.NewRequest("GET", "/api2/sub2/", nil)
 	rrSub2 := httptest.NewRecorder()
-	app.handler.ServeHTTP(rrSub2, reqSub2)
+	app.ServeHTTP(rrSub2, reqSub2)
 	if rrSub2.Code != http.StatusOK {
 		t.Errorf("Expected status OK for /api2/sub2, got %d", rrSub2.Code)
 	}
@@ -335,11 +348,11 @@ func TestHandlePathPattern(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			app = NewServer()
 			called = false
-			app.handle(tt.path, "GET", handler)
+			app.Get(tt.path, handler)
 
 			req := httptest.NewRequest("GET", tt.expectedPath, nil)
 			rr := httptest.NewRecorder()
-			app.handler.ServeHTTP(rr, req)
+			app.ServeHTTP(rr, req)
 
 			if rr.Code != http.StatusOK {
 				t.Errorf("For path %s, expected status OK, got %d", tt.path, rr.Code)
@@ -351,7 +364,7 @@ func TestHandlePathPattern(t *testing.T) {
 			called = false
 			reqNotFound := httptest.NewRequest("GET", "/nonexistent/", nil)
 			rrNotFound := httptest.NewRecorder()
-			app.handler.ServeHTTP(rrNotFound, reqNotFound)
+			app.ServeHTTP(rrNotFound, reqNotFound)
 			if rrNotFound.Code != http.StatusNotFound {
 				t.Errorf("For non-matching path, expected status Not Found, got %d", rrNotFound.Code)
 			}
@@ -456,7 +469,7 @@ func TestSetUpMiddlewares(t *testing.T) {
 		}
 	}
 
-	mws := []middleware{mw1, mw2}
+	mws := []Middleware{mw1, mw2}
 	chainedHandler := setUpMiddlewares(finalHandler, mws)
 
 	req := httptest.NewRequest("GET", "/", nil)
@@ -503,7 +516,7 @@ func TestApp_MiddlewareExecution(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/test/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	expectedOrder := []string{"globalMw1", "globalMw2", "finalHandler"}
 	if len(callOrder) != len(expectedOrder) {
@@ -547,7 +560,7 @@ func TestRouter_MiddlewareExecution(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/api/subroute/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	expectedOrder := []string{"routerMw1", "routerMw2", "finalSubHandler"}
 	if len(callOrder) != len(expectedOrder) {
@@ -592,7 +605,7 @@ func TestAppAndRouter_MiddlewareCombinedExecution(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/data/item/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	expectedOrder := []string{"globalAppMw", "routerMw", "finalItemHandler"}
 	if len(callOrder) != len(expectedOrder) {
@@ -646,7 +659,7 @@ func TestDeepNestedMiddlewareCombinedExecution(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/api/nested/resource/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	expectedOrder := []string{"globalAppMw", "router1Mw", "router2Mw", "finalResourceHandler"}
 
@@ -685,7 +698,7 @@ func TestMiddlewareCanHaltExecution(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/protected/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	expectedOrder := []string{"haltingMw"}
 	if len(callOrder) != len(expectedOrder) {
@@ -731,7 +744,7 @@ func TestMiddlewareCanModifyRequest(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/info/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK, got %d", rr.Code)
@@ -761,7 +774,7 @@ func TestApp_PathParameter(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/users/123/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK, got %d", rr.Code)
@@ -794,7 +807,7 @@ func TestApp_MultiplePathParameters(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/users/user456/books/book789/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK, got %d", rr.Code)
@@ -823,7 +836,7 @@ func TestRouter_PathParameter(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/api/users/987/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK, got %d", rr.Code)
@@ -861,7 +874,7 @@ func TestRouter_NestedPathParameters(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/store/products/prodA/reviews/revB/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK, got %d", rr.Code)
@@ -901,7 +914,7 @@ func TestPathParameter_WithMiddleware(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/items/42/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK, got %d", rr.Code)
@@ -932,7 +945,7 @@ func TestApp_PathParameter_WithTrailingSlash(t *testing.T) {
 	// Probar con trailing slash
 	req := httptest.NewRequest("GET", "/widgets/55/", nil)
 	rr := httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK for trailing slash, got %d", rr.Code)
@@ -945,9 +958,1906 @@ func TestApp_PathParameter_WithTrailingSlash(t *testing.T) {
 	// Probar sin trailing slash (para asegurar que ambas funcionan)
 	req = httptest.NewRequest("GET", "/widgets/55", nil)
 	rr = httptest.NewRecorder()
-	app.handler.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusMovedPermanently {
+	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK for no trailing slash, got %d", rr.Code)
 	}
 }
+
+func TestApp_ListenContext_DrainsInFlightRequests(t *testing.T) {
+	app := NewServer(WithShutdownTimeout(time.Second))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	app.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	var hookCalled bool
+	app.OnShutdown(func(ctx context.Context) error {
+		hookCalled = true
+		return nil
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- app.serveContext(ctx, ln)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow/")
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resc <- result{body: string(body), err: err}
+	}()
+
+	<-started
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	res := <-resc
+	if res.err != nil {
+		t.Fatalf("In-flight request failed: %v", res.err)
+	}
+	if res.body != "done" {
+		t.Errorf("Expected body 'done', got '%s'", res.body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+	if !hookCalled {
+		t.Error("Shutdown hook was not called")
+	}
+}
+
+func TestApp_Shutdown_WithoutListen(t *testing.T) {
+	app := NewServer()
+	var calls int
+	app.OnShutdown(func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected no error on second shutdown, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected shutdown hook to run once, got %d", calls)
+	}
+}
+
+func TestApp_Shutdown_BeforeListen(t *testing.T) {
+	app := NewServer()
+	app.Get("/hello", func(w http.ResponseWriter, r *http.Request) {})
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	served := make(chan error, 1)
+	go func() {
+		served <- app.serveContext(context.Background(), ln)
+	}()
+	select {
+	case err := <-served:
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("Expected http.ErrServerClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the server not to start after Shutdown")
+	}
+}
+
+func TestApp_ServeHTTP(t *testing.T) {
+	app := NewServer()
+	app.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	srv := httptest.NewServer(&app)
+	defer srv.Close()
+
+	for range 2 {
+		resp, err := http.Get(srv.URL + "/hello/")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status OK, got %d", resp.StatusCode)
+		}
+		if string(body) != "hello" {
+			t.Errorf("Expected body 'hello', got '%s'", string(body))
+		}
+	}
+}
+
+func TestApp_ServeHTTP_MountedUnderMux(t *testing.T) {
+	app := NewServer()
+	app.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/svc/", http.StripPrefix("/svc", &app))
+
+	req := httptest.NewRequest("GET", "/svc/ping/", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", rr.Code)
+	}
+	if rr.Body.String() != "pong" {
+		t.Errorf("Expected body 'pong', got '%s'", rr.Body.String())
+	}
+}
+
+func TestApp_ExtendedMethods(t *testing.T) {
+	app := NewServer()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method))
+	}
+	app.Patch("/res", handler)
+	app.Head("/res", handler)
+	app.Options("/res", handler)
+	app.Trace("/res", handler)
+	app.Handle("PURGE", "/res", handler)
+
+	for _, method := range []string{"PATCH", "HEAD", "OPTIONS", "TRACE", "PURGE"} {
+		req := httptest.NewRequest(method, "/res/", nil)
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status OK, got %d", method, rr.Code)
+		}
+		if method != "HEAD" && rr.Body.String() != method {
+			t.Errorf("%s: expected body '%s', got '%s'", method, method, rr.Body.String())
+		}
+	}
+}
+
+func TestRouter_Any(t *testing.T) {
+	rtr := NewRouter()
+	rtr.Any("/all", func(w http.ResponseWriter, r *http.Request) {})
+	rtr.Any("/all", func(w http.ResponseWriter, r *http.Request) {})
+
+	if len(rtr.routes) != len(methods) {
+		t.Fatalf("Expected %d routes, got %d", len(methods), len(rtr.routes))
+	}
+	for i, method := range methods {
+		if rtr.routes[i].method != method {
+			t.Errorf("At index %d, expected method %s, got %s", i, method, rtr.routes[i].method)
+		}
+	}
+
+	app := NewServer()
+	app.UseRouter("/api", rtr)
+
+	req := httptest.NewRequest("PATCH", "/api/all/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", rr.Code)
+	}
+}
+
+func TestApp_AutomaticOptions(t *testing.T) {
+	app := NewServer()
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	app.Get("/users/{id}", handler)
+
+	rtr := NewRouter()
+	rtr.Delete("/{userID}", handler)
+	app.UseRouter("/users", rtr)
+
+	req := httptest.NewRequest("OPTIONS", "/users/7/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status No Content, got %d", rr.Code)
+	}
+	if allow := rr.Header().Get("Allow"); allow != "DELETE, GET, HEAD, OPTIONS" {
+		t.Errorf("Expected Allow 'DELETE, GET, HEAD, OPTIONS', got '%s'", allow)
+	}
+}
+
+func TestApp_MethodNotAllowed(t *testing.T) {
+	app := NewServer()
+	app.Post("/items", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("GET", "/items/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status Method Not Allowed, got %d", rr.Code)
+	}
+	if allow := rr.Header().Get("Allow"); allow != "OPTIONS, POST" {
+		t.Errorf("Expected Allow 'OPTIONS, POST', got '%s'", allow)
+	}
+}
+
+func TestApp_MethodNotAllowed_CustomHandler(t *testing.T) {
+	app := NewServer()
+	app.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("try " + w.Header().Get("Allow")))
+	})
+	app.Put("/items", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("PATCH", "/items/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status Method Not Allowed, got %d", rr.Code)
+	}
+	if rr.Body.String() != "try OPTIONS, PUT" {
+		t.Errorf("Expected body 'try OPTIONS, PUT', got '%s'", rr.Body.String())
+	}
+}
+
+func TestApp_ExplicitOptionsRouteWins(t *testing.T) {
+	app := NewServer()
+	app.Get("/items", func(w http.ResponseWriter, r *http.Request) {})
+	app.Options("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("custom"))
+	})
+
+	req := httptest.NewRequest("OPTIONS", "/items/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", rr.Code)
+	}
+	if rr.Body.String() != "custom" {
+		t.Errorf("Expected body 'custom', got '%s'", rr.Body.String())
+	}
+}
+
+func TestApp_NotFound_RunsThroughMiddleware(t *testing.T) {
+	app := NewServer()
+	var callOrder []string
+	app.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			callOrder = append(callOrder, "globalMw")
+			next(w, r)
+		}
+	})
+	app.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		callOrder = append(callOrder, "notFound")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("nothing here"))
+	})
+	app.Get("/known", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("GET", "/unknown/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found, got %d", rr.Code)
+	}
+	if rr.Body.String() != "nothing here" {
+		t.Errorf("Expected body 'nothing here', got '%s'", rr.Body.String())
+	}
+	expectedOrder := []string{"globalMw", "notFound"}
+	if len(callOrder) != len(expectedOrder) {
+		t.Fatalf("Expected call order %v, got %v", expectedOrder, callOrder)
+	}
+	for i, expected := range expectedOrder {
+		if callOrder[i] != expected {
+			t.Errorf("At index %d, expected '%s', got '%s'", i, expected, callOrder[i])
+		}
+	}
+}
+
+func TestApp_MethodNotAllowed_RunsThroughMiddleware(t *testing.T) {
+	app := NewServer()
+	var middlewareCalled bool
+	app.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			middlewareCalled = true
+			next(w, r)
+		}
+	})
+	app.Get("/known", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("POST", "/known/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status Method Not Allowed, got %d", rr.Code)
+	}
+	if !middlewareCalled {
+		t.Error("Middleware was not called for 405 response")
+	}
+}
+
+func TestRouter_NotFound_Scoped(t *testing.T) {
+	app := NewServer()
+	app.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("app"))
+	})
+
+	var routerMwCalled bool
+	api := NewRouter()
+	api.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			routerMwCalled = true
+			next(w, r)
+		}
+	})
+	api.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("api"))
+	})
+
+	v1 := NewRouter()
+	v1.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("v1"))
+	})
+	api.UseRouter("/v1", v1)
+	app.UseRouter("/api", api)
+
+	tests := []struct {
+		path         string
+		expectedBody string
+	}{
+		{"/other/", "app"},
+		{"/api/missing/", "api"},
+		{"/api/v1/missing/", "v1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("For %s, expected status Not Found, got %d", tt.path, rr.Code)
+		}
+		if rr.Body.String() != tt.expectedBody {
+			t.Errorf("For %s, expected body '%s', got '%s'", tt.path, tt.expectedBody, rr.Body.String())
+		}
+	}
+	if !routerMwCalled {
+		t.Error("Router middleware was not called for scoped not-found handler")
+	}
+}
+
+func TestApp_Validate_DuplicateRoute(t *testing.T) {
+	app := NewServer()
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	app.Get("/users", handler)
+	app.Get("/users", handler)
+
+	err := app.Validate()
+	if err == nil {
+		t.Fatal("Expected an error for duplicate route, got nil")
+	}
+	var routeErr *RouteError
+	if !errors.As(err, &routeErr) {
+		t.Fatalf("Expected a *RouteError, got %T", err)
+	}
+	if !strings.Contains(routeErr.Source, "cafe_test.go:") {
+		t.Errorf("Expected source in cafe_test.go, got '%s'", routeErr.Source)
+	}
+	if routeErr.Source == routeErr.OtherSource {
+		t.Errorf("Expected distinct registration sites, got '%s' twice", routeErr.Source)
+	}
+}
+
+func TestApp_Validate_DuplicateRouter(t *testing.T) {
+	app := NewServer()
+	app.UseRouter("/api", NewRouter())
+	app.UseRouter("/api", NewRouter())
+
+	inner := NewRouter()
+	inner.UseRouter("/v1", NewRouter())
+	inner.UseRouter("/v1", NewRouter())
+	app.UseRouter("/inner", inner)
+
+	err := app.Validate()
+	if err == nil {
+		t.Fatal("Expected an error for duplicate routers, got nil")
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+		t.Errorf("Expected 2 errors, got %d: %v", n, err)
+	}
+}
+
+func TestApp_Validate_ConflictAfterPrefixJoin(t *testing.T) {
+	app := NewServer()
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	app.Get("/api/{x}", handler)
+
+	rtr := NewRouter()
+	rtr.Get("/{id}", handler)
+	app.UseRouter("/api", rtr)
+
+	err := app.Validate()
+	if err == nil {
+		t.Fatal("Expected a conflict error, got nil")
+	}
+	var routeErr *RouteError
+	if !errors.As(err, &routeErr) {
+		t.Fatalf("Expected a *RouteError, got %T", err)
+	}
+	if routeErr.Pattern != "GET /api/{id}/{$}" || routeErr.Other != "GET /api/{x}/{$}" {
+		t.Errorf("Unexpected conflict: %v", routeErr)
+	}
+
+	// Serving must not panic; the first registration wins.
+	req := httptest.NewRequest("GET", "/api/1/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", rr.Code)
+	}
+
+	if err := app.ListenContext(context.Background(), "127.0.0.1:0"); err == nil {
+		t.Error("Expected ListenContext to refuse an invalid route table")
+	}
+}
+
+func TestApp_Validate_Valid(t *testing.T) {
+	app := NewServer()
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	app.Get("/a/{x}", handler)
+	app.Post("/{y}/b", handler)
+
+	if err := app.Validate(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func listUsers(w http.ResponseWriter, r *http.Request) {}
+
+func TestApp_Routes(t *testing.T) {
+	app := NewServer()
+	passthrough := func(next http.HandlerFunc) http.HandlerFunc { return next }
+	app.Use(passthrough)
+	app.Get("/health", func(w http.ResponseWriter, r *http.Request) {})
+
+	admin := NewRouter()
+	admin.Use(passthrough)
+	admin.Get("/users", listUsers)
+
+	api := NewRouter()
+	api.Use(passthrough)
+	api.UseRouter("/admin", admin)
+	app.UseRouter("/api", api)
+
+	routes := app.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 routes, got %d", len(routes))
+	}
+
+	health := routes[0]
+	if health.Method != "GET" || health.Path != "/health" {
+		t.Errorf("Unexpected first route: %+v", health)
+	}
+	if len(health.Mounts) != 0 || health.Middlewares != 1 {
+		t.Errorf("Expected no mounts and 1 middleware, got %+v", health)
+	}
+
+	users := routes[1]
+	if users.Path != "/api/admin/users" {
+		t.Errorf("Expected path /api/admin/users, got %s", users.Path)
+	}
+	if len(users.Mounts) != 2 || users.Mounts[0] != "/api" || users.Mounts[1] != "/admin" {
+		t.Errorf("Expected mounts [/api /admin], got %v", users.Mounts)
+	}
+	if users.Middlewares != 3 {
+		t.Errorf("Expected 3 middlewares, got %d", users.Middlewares)
+	}
+	if users.Handler != "github.com/LucasSim0n/cafe.listUsers" {
+		t.Errorf("Expected handler name listUsers, got %s", users.Handler)
+	}
+	if !strings.Contains(users.Source, "cafe_test.go:") {
+		t.Errorf("Expected source in cafe_test.go, got %s", users.Source)
+	}
+}
+
+func TestApp_PrintRoutes(t *testing.T) {
+	app := NewServer()
+	app.Get("/users", listUsers)
+
+	var sb strings.Builder
+	if err := app.PrintRoutes(&sb); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := "METHOD  PATH    MIDDLEWARES  HANDLER\n" +
+		"GET     /users  0            github.com/LucasSim0n/cafe.listUsers\n"
+	if sb.String() != expected {
+		t.Errorf("Expected table:\n%s\ngot:\n%s", expected, sb.String())
+	}
+}
+
+func TestApp_RoutesHandler(t *testing.T) {
+	app := NewServer()
+	app.Get("/users", listUsers)
+	app.Get("/debug/routes", app.RoutesHandler())
+
+	req := httptest.NewRequest("GET", "/debug/routes/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	var routes []RouteInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &routes); err != nil {
+		t.Fatalf("Expected JSON body, got error %v", err)
+	}
+	if len(routes) != 2 || routes[0].Path != "/users" {
+		t.Errorf("Unexpected routes: %+v", routes)
+	}
+}
+
+func TestRouteMiddleware_Order(t *testing.T) {
+	app := NewServer()
+	var callOrder []string
+	track := func(name string) Middleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				callOrder = append(callOrder, name)
+				next(w, r)
+			}
+		}
+	}
+
+	app.Use(track("appMw"))
+	rtr := NewRouter()
+	rtr.Use(track("routerMw"))
+	rtr.Post("/users", func(w http.ResponseWriter, r *http.Request) {
+		callOrder = append(callOrder, "handler")
+	}, track("auth"), track("audit"))
+	rtr.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+		callOrder = append(callOrder, "handler")
+	})
+	app.UseRouter("/api", rtr)
+
+	req := httptest.NewRequest("POST", "/api/users/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	expectedOrder := []string{"appMw", "routerMw", "auth", "audit", "handler"}
+	if len(callOrder) != len(expectedOrder) {
+		t.Fatalf("Expected call order %v, got %v", expectedOrder, callOrder)
+	}
+	for i, expected := range expectedOrder {
+		if callOrder[i] != expected {
+			t.Errorf("At index %d, expected '%s', got '%s'", i, expected, callOrder[i])
+		}
+	}
+
+	callOrder = nil
+	req = httptest.NewRequest("GET", "/api/users/", nil)
+	app.ServeHTTP(httptest.NewRecorder(), req)
+	if len(callOrder) != 3 {
+		t.Errorf("Expected route middlewares to be scoped to POST, got %v", callOrder)
+	}
+
+	routes := app.Routes()
+	if routes[0].Middlewares != 4 {
+		t.Errorf("Expected 4 middlewares on POST route, got %d", routes[0].Middlewares)
+	}
+}
+
+func TestFromHandler(t *testing.T) {
+	app := NewServer()
+	var callOrder []string
+	std := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			callOrder = append(callOrder, "std")
+			next.ServeHTTP(w, r)
+		})
+	}
+	native := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			callOrder = append(callOrder, "native")
+			next(w, r)
+		}
+	}
+	app.Use(FromHandler(std), native)
+	app.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		callOrder = append(callOrder, "handler")
+	})
+
+	req := httptest.NewRequest("GET", "/test/", nil)
+	app.ServeHTTP(httptest.NewRecorder(), req)
+
+	expectedOrder := []string{"std", "native", "handler"}
+	if len(callOrder) != len(expectedOrder) {
+		t.Fatalf("Expected call order %v, got %v", expectedOrder, callOrder)
+	}
+	for i, expected := range expectedOrder {
+		if callOrder[i] != expected {
+			t.Errorf("At index %d, expected '%s', got '%s'", i, expected, callOrder[i])
+		}
+	}
+}
+
+func TestMiddleware_Handler(t *testing.T) {
+	var mw Middleware = func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Wrapped", "yes")
+			next(w, r)
+		}
+	}
+	h := mw.Handler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Header().Get("X-Wrapped") != "yes" {
+		t.Error("Expected middleware to run")
+	}
+	if rr.Body.String() != "ok" {
+		t.Errorf("Expected body 'ok', got '%s'", rr.Body.String())
+	}
+}
+
+func TestMount(t *testing.T) {
+	app := NewServer()
+	var callOrder []string
+	app.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			callOrder = append(callOrder, "appMw:"+r.URL.Path)
+			next(w, r)
+		}
+	})
+
+	legacy := http.NewServeMux()
+	legacy.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method + " " + r.URL.Path))
+	})
+	app.Mount("/legacy", legacy)
+
+	tests := []struct {
+		method       string
+		path         string
+		expectedBody string
+	}{
+		{"GET", "/legacy/", "GET /"},
+		{"POST", "/legacy/a/b", "POST /a/b"},
+		{"DELETE", "/legacy/a/", "DELETE /a/"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("For %s %s, expected status OK, got %d", tt.method, tt.path, rr.Code)
+		}
+		if rr.Body.String() != tt.expectedBody {
+			t.Errorf("For %s %s, expected body '%s', got '%s'", tt.method, tt.path, tt.expectedBody, rr.Body.String())
+		}
+	}
+	if len(callOrder) != 3 || callOrder[1] != "appMw:/legacy/a/b" {
+		t.Errorf("Expected app middleware to see the full path, got %v", callOrder)
+	}
+}
+
+func TestRouter_Mount_NestedWithWildcard(t *testing.T) {
+	app := NewServer()
+	var routerMwCalled bool
+
+	files := NewRouter()
+	files.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			routerMwCalled = true
+			next(w, r)
+		}
+	})
+	files.Mount("/raw", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.PathValue("tenant") + " " + r.URL.Path))
+	}))
+
+	tenants := NewRouter()
+	tenants.UseRouter("/{tenant}/files", files)
+	app.UseRouter("/t", tenants)
+
+	req := httptest.NewRequest("GET", "/t/acme/files/raw/docs/a%2Fb.txt", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", rr.Code)
+	}
+	if rr.Body.String() != "acme /docs/a/b.txt" {
+		t.Errorf("Expected body 'acme /docs/a/b.txt', got '%s'", rr.Body.String())
+	}
+	if !routerMwCalled {
+		t.Error("Router middleware was not called for mounted handler")
+	}
+}
+
+func TestCatchAllRoute(t *testing.T) {
+	app := NewServer()
+	app.Get("/files/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("file:" + r.PathValue("path")))
+	})
+
+	tests := []struct {
+		path         string
+		expectedBody string
+	}{
+		{"/files/", "file:"},
+		{"/files/a.txt", "file:a.txt"},
+		{"/files/docs/2024/report.pdf", "file:docs/2024/report.pdf"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("For %s, expected status OK, got %d", tt.path, rr.Code)
+		}
+		if rr.Body.String() != tt.expectedBody {
+			t.Errorf("For %s, expected body '%s', got '%s'", tt.path, tt.expectedBody, rr.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/files/a.txt", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status Method Not Allowed, got %d", rr.Code)
+	}
+
+	if err := app.Validate(); err != nil {
+		t.Errorf("Expected no validation error, got %v", err)
+	}
+}
+
+func TestRouter_CatchAll_MountedTwice(t *testing.T) {
+	app := NewServer()
+	assets := NewRouter()
+	assets.Prefix("GET", "/static", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.PathValue("tenant") + ":" + r.PathValue(RestParam)))
+	})
+
+	app.UseRouter("/public", assets)
+	app.UseRouter("/t/{tenant}", assets)
+
+	tests := []struct {
+		path         string
+		expectedBody string
+	}{
+		{"/public/static/css/site.css", ":css/site.css"},
+		{"/t/acme/static/js/app.js", "acme:js/app.js"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("For %s, expected status OK, got %d", tt.path, rr.Code)
+		}
+		if rr.Body.String() != tt.expectedBody {
+			t.Errorf("For %s, expected body '%s', got '%s'", tt.path, tt.expectedBody, rr.Body.String())
+		}
+	}
+}
+
+func TestApp_URL(t *testing.T) {
+	app := NewServer()
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	app.Get("/health", handler).Name("health")
+
+	users := NewRouter()
+	users.Get("/{id}", handler).Name("user.show")
+	users.Get("/{id}/files/{path...}", handler).Name("user.file")
+
+	orgs := NewRouter()
+	orgs.UseRouter("/{org}/users", users)
+	app.UseRouter("/orgs", orgs)
+
+	tests := []struct {
+		name     string
+		params   []string
+		expected string
+	}{
+		{"health", nil, "/health"},
+		{"user.show", []string{"org", "acme", "id", "42"}, "/orgs/acme/users/42"},
+		{"user.show", []string{"org", "a b", "id", "x/y"}, "/orgs/a%20b/users/x%2Fy"},
+		{"user.file", []string{"org", "acme", "id", "1", "path", "docs/a b.txt"}, "/orgs/acme/users/1/files/docs/a%20b.txt"},
+	}
+	for _, tt := range tests {
+		got, err := app.URL(tt.name, tt.params...)
+		if err != nil {
+			t.Errorf("URL(%q) returned error %v", tt.name, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("URL(%q), expected '%s', got '%s'", tt.name, tt.expected, got)
+		}
+	}
+
+	if _, err := app.URL("user.show", "id", "42"); err == nil || !strings.Contains(err.Error(), "org") {
+		t.Errorf("Expected missing parameter error mentioning org, got %v", err)
+	}
+	if _, err := app.URL("user.show", "id"); err == nil {
+		t.Error("Expected error for odd number of parameters")
+	}
+	if _, err := app.URL("nope"); err == nil {
+		t.Error("Expected error for unknown route name")
+	}
+}
+
+func TestApp_URL_NamedAfterServing(t *testing.T) {
+	app := NewServer()
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	app.Get("/health", handler).Name("health")
+	if _, err := app.URL("health"); err != nil {
+		t.Fatalf("URL returned error %v", err)
+	}
+	if app.handler.Load() != nil {
+		t.Error("expected URL not to build the route table")
+	}
+
+	api := NewRouter()
+	app.UseRouter("/api", api)
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	app.Get("/users/{id}", handler).Name("user.show")
+	api.Get("/items/{id}", handler).Name("item.show")
+
+	for name, expected := range map[string]string{"user.show": "/users/1", "item.show": "/api/items/1"} {
+		got, err := app.URL(name, "id", "1")
+		if err != nil {
+			t.Errorf("URL(%q) returned error %v", name, err)
+		} else if got != expected {
+			t.Errorf("URL(%q), expected '%s', got '%s'", name, expected, got)
+		}
+	}
+}
+
+func TestApp_Validate_DuplicateName(t *testing.T) {
+	app := NewServer()
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	app.Any("/any", handler).Name("any")
+	app.Get("/a", handler).Name("dup")
+	app.Get("/b", handler).Name("dup")
+
+	err := app.Validate()
+	if err == nil {
+		t.Fatal("Expected an error for duplicate route name, got nil")
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 1 {
+		t.Errorf("Expected 1 error, got %d: %v", n, err)
+	}
+
+	routes := app.Routes()
+	if routes[0].Name != "any" {
+		t.Errorf("Expected route name 'any' in route info, got '%s'", routes[0].Name)
+	}
+}
+
+func TestPathConstraints(t *testing.T) {
+	app := NewServer()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}
+	app.Get("/users/{id:int}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := PathInt(r, "id")
+		if err != nil {
+			t.Errorf("PathInt returned error %v", err)
+		}
+		fmt.Fprintf(w, "user %d", id)
+	})
+	app.Get("/files/{name:[a-z]{2,4}}", handler)
+	app.Get("/tickets/{status:open|closed}", handler)
+	app.Get("/orders/{id:uuid}", handler)
+
+	tests := []struct {
+		path         string
+		expectedCode int
+	}{
+		{"/users/42/", http.StatusOK},
+		{"/users/-7/", http.StatusOK},
+		{"/users/abc/", http.StatusBadRequest},
+		{"/files/abc/", http.StatusOK},
+		{"/files/abcdef/", http.StatusBadRequest},
+		{"/files/AB/", http.StatusBadRequest},
+		{"/tickets/open/", http.StatusOK},
+		{"/tickets/pending/", http.StatusBadRequest},
+		{"/orders/123e4567-e89b-12d3-a456-426614174000/", http.StatusOK},
+		{"/orders/123/", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("For %s, expected status %d, got %d", tt.path, tt.expectedCode, rr.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/users/42/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Body.String() != "user 42" {
+		t.Errorf("Expected body 'user 42', got '%s'", rr.Body.String())
+	}
+
+	if routes := app.Routes(); routes[0].Path != "/users/{id}" {
+		t.Errorf("Expected constraint to be stripped from path, got %s", routes[0].Path)
+	}
+}
+
+func TestPathConstraints_MountPrefixAndNotFoundStatus(t *testing.T) {
+	app := NewServer(WithInvalidParamStatus(http.StatusNotFound))
+	app.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("custom 404"))
+	})
+
+	rtr := NewRouter()
+	rtr.Get("/items/{item:uint}", func(w http.ResponseWriter, r *http.Request) {
+		org, _ := PathInt64(r, "org")
+		item, _ := PathUint(r, "item")
+		fmt.Fprintf(w, "%d/%d", org, item)
+	})
+	app.UseRouter("/orgs/{org:int}", rtr)
+
+	req := httptest.NewRequest("GET", "/orgs/3/items/9/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "3/9" {
+		t.Errorf("Expected 200 '3/9', got %d '%s'", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/orgs/acme/items/9/", nil)
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound || rr.Body.String() != "custom 404" {
+		t.Errorf("Expected custom 404, got %d '%s'", rr.Code, rr.Body.String())
+	}
+
+	api := NewRouter()
+	api.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("api 404"))
+	})
+	v1 := NewRouter()
+	v1.Get("/users/{id:int}", func(w http.ResponseWriter, r *http.Request) {})
+	api.UseRouter("/v1", v1)
+	app.UseRouter("/api", api)
+
+	req = httptest.NewRequest("GET", "/api/v1/users/x", nil)
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound || rr.Body.String() != "api 404" {
+		t.Errorf("Expected the router's 404, got %d '%s'", rr.Code, rr.Body.String())
+	}
+
+	teapot := NewServer(WithInvalidParamStatus(http.StatusTeapot))
+	if err := teapot.Validate(); err == nil || !strings.Contains(err.Error(), "WithInvalidParamStatus(418)") {
+		t.Errorf("Expected an unsupported invalid param status to be rejected, got %v", err)
+	}
+}
+
+func TestPathConstraints_InvalidExpression(t *testing.T) {
+	app := NewServer()
+	app.Get("/bad/{id:[a-z}", func(w http.ResponseWriter, r *http.Request) {})
+	app.Get("/bad/{id:(}", func(w http.ResponseWriter, r *http.Request) {})
+
+	err := app.Validate()
+	if err == nil {
+		t.Fatal("Expected an error for invalid constraints, got nil")
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+		t.Errorf("Expected 2 errors, got %d: %v", n, err)
+	}
+}
+
+func TestApp_Host(t *testing.T) {
+	app := NewServer()
+	app.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("default"))
+	})
+
+	api := NewRouter()
+	api.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("api"))
+	})
+	app.Host("api.example.com", api)
+
+	tenants := NewRouter()
+	tenants.Get("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tenant " + r.PathValue("tenant")))
+	})
+	app.Host("{tenant}.example.com", tenants)
+
+	tests := []struct {
+		host         string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"example.org", "/", http.StatusOK, "default"},
+		{"api.example.com", "/", http.StatusOK, "api"},
+		{"API.example.com:8080", "/", http.StatusOK, "api"},
+		{"acme.example.com", "/dashboard/", http.StatusOK, "tenant acme"},
+		{"acme.example.com", "/", http.StatusNotFound, `{"instance":"/","status":404,"title":"Not Found"}` + "\n"},
+		{"a.b.example.com", "/dashboard/", http.StatusNotFound, `{"instance":"/dashboard/","status":404,"title":"Not Found"}` + "\n"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Host = tt.host
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("For %s%s, expected status %d, got %d", tt.host, tt.path, tt.expectedCode, rr.Code)
+		}
+		if rr.Body.String() != tt.expectedBody {
+			t.Errorf("For %s%s, expected body '%s', got '%s'", tt.host, tt.path, tt.expectedBody, rr.Body.String())
+		}
+	}
+
+	routes := app.Routes()
+	if len(routes) != 3 || routes[1].Host != "api.example.com" || routes[2].Host != "{tenant}.example.com" {
+		t.Errorf("Unexpected host routes: %+v", routes)
+	}
+}
+
+func TestApp_Host_ConstraintsAndValidation(t *testing.T) {
+	app := NewServer()
+	tenants := NewRouter()
+	tenants.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.PathValue("id")))
+	})
+	app.Host("{id:int}.example.com", tenants)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "42.example.com"
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Body.String() != "42" {
+		t.Errorf("Expected body '42', got '%s'", rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Host = "acme.example.com"
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found, got %d", rr.Code)
+	}
+
+	app2 := NewServer()
+	app2.Host("api.example.com", NewRouter())
+	app2.Host("API.example.com", NewRouter())
+	app2.Host("x{bad}.example.com", NewRouter())
+	err := app2.Validate()
+	if err == nil {
+		t.Fatal("Expected host registration errors, got nil")
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+		t.Errorf("Expected 2 errors, got %d: %v", n, err)
+	}
+
+	app3 := NewServer()
+	api := NewRouter()
+	api.Get("/x", func(w http.ResponseWriter, r *http.Request) {})
+	api.Get("/x", func(w http.ResponseWriter, r *http.Request) {})
+	api.Get("/x/{id:[}", func(w http.ResponseWriter, r *http.Request) {})
+	app3.Host("api.example.com", api)
+	err = app3.Validate()
+	if err == nil {
+		t.Fatal("Expected host router errors, got nil")
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+		t.Errorf("Expected 2 errors, got %d: %v", n, err)
+	}
+}
+
+func TestTrailingSlash_Strict(t *testing.T) {
+	app := NewServer(WithTrailingSlash(TrailingSlashStrict))
+	app.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+	app.Get("/teams/", func(w http.ResponseWriter, r *http.Request) {})
+	app.Post("/teams/", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/users", http.StatusOK},
+		{"GET", "/users/", http.StatusNotFound},
+		{"GET", "/teams/", http.StatusOK},
+		{"GET", "/teams", http.StatusNotFound},
+		{"POST", "/teams", http.StatusNotFound},
+		{"DELETE", "/users", http.StatusMethodNotAllowed},
+		{"GET", "//users", http.StatusNotFound},
+		{"GET", "/./users", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		if rr.Code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.want, rr.Code)
+		}
+	}
+}
+
+func TestTrailingSlash_StrictScopedNotFound(t *testing.T) {
+	app := NewServer(WithTrailingSlash(TrailingSlashStrict))
+	api := NewRouter()
+	api.Get("/u", func(w http.ResponseWriter, r *http.Request) {})
+	api.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	app.UseRouter("/api", api)
+	app.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/api/zzz", http.StatusTeapot},
+		{"/api/u/", http.StatusTeapot},
+		{"/api//u", http.StatusTeapot},
+		{"/users/", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+		if rr.Code != tt.want {
+			t.Errorf("GET %s: expected %d, got %d", tt.path, tt.want, rr.Code)
+		}
+	}
+}
+
+func TestTrailingSlash_Redirect(t *testing.T) {
+	app := NewServer(WithTrailingSlash(TrailingSlashRedirect))
+	app.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+	app.Post("/teams/", func(w http.ResponseWriter, r *http.Request) {})
+	app.Get("/files/{path...}", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		method, path string
+		want         int
+		location     string
+	}{
+		{"GET", "/users", http.StatusOK, ""},
+		{"GET", "/users/?page=2", http.StatusMovedPermanently, "/users?page=2"},
+		{"POST", "/teams", http.StatusPermanentRedirect, "/teams/"},
+		{"GET", "/files", http.StatusMovedPermanently, "/files/"},
+		{"GET", "/a/../users", http.StatusMovedPermanently, "/users"},
+		{"POST", "//teams/", http.StatusPermanentRedirect, "/teams/"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		if rr.Code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.want, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s %s: expected Location %q, got %q", tt.method, tt.path, tt.location, loc)
+		}
+	}
+}
+
+func TestTrailingSlash_LenientCleansPaths(t *testing.T) {
+	app := NewServer()
+	app.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.PathValue("id"))
+	})
+
+	for _, path := range []string{"/users/7", "/users/7/", "//users/7", "/users/./7", "/x/../users/7"} {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != "7" {
+			t.Errorf("GET %s: expected 200 with 7, got %d %q", path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestTrailingSlash_CaseInsensitive(t *testing.T) {
+	app := NewServer(WithCaseInsensitivePaths(), WithTrailingSlash(TrailingSlashRedirect))
+	app.Get("/Users/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.PathValue("userID"))
+	})
+
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/users/ab", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "ab" {
+		t.Errorf("expected 200 with ab, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/USERS/AB", nil))
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/users/AB" {
+		t.Errorf("expected 301 to /users/AB, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/users/AB", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "AB" {
+		t.Errorf("expected 200 with AB, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/Users/AB/", nil))
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/users/AB" {
+		t.Errorf("expected 301 to /users/AB, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestTrailingSlash_CaseInsensitiveLenient(t *testing.T) {
+	app := NewServer(WithCaseInsensitivePaths())
+	app.Get("/tokens/{tok}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path+" "+r.PathValue("tok"))
+	})
+	app.Get("/files/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.PathValue("path"))
+	})
+
+	tests := []struct{ path, want string }{
+		{"/tokens/AbC", "/tokens/AbC AbC"},
+		{"/TOKENS/AbC/", "/tokens/AbC/ AbC"},
+		{"/Files/Docs/A.txt", "Docs/A.txt"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != tt.want {
+			t.Errorf("GET %s: expected 200 with %q, got %d %q", tt.path, tt.want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestApp_RuntimeRegistration(t *testing.T) {
+	app := NewServer()
+	app.Get("/a", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "a") })
+	api := NewRouter()
+	app.UseRouter("/api", api)
+
+	get := func(path string) int {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr.Code
+	}
+	if code := get("/b"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 before registration, got %d", code)
+	}
+
+	app.Get("/b", func(w http.ResponseWriter, r *http.Request) {})
+	api.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+	if code := get("/b"); code != http.StatusOK {
+		t.Errorf("expected 200 for a route added at runtime, got %d", code)
+	}
+	if code := get("/api/users"); code != http.StatusOK {
+		t.Errorf("expected 200 for a router route added at runtime, got %d", code)
+	}
+
+	if !app.RemoveRoute("GET", "/a") {
+		t.Error("expected RemoveRoute to find /a")
+	}
+	if app.RemoveRoute("GET", "/a") {
+		t.Error("expected a second RemoveRoute to find nothing")
+	}
+	if code := get("/a"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for a removed route, got %d", code)
+	}
+
+	if !app.RemoveRouter("/api") {
+		t.Error("expected RemoveRouter to find /api")
+	}
+	if code := get("/api/users"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for a removed router, got %d", code)
+	}
+	if n := len(api.watchers); n != 0 {
+		t.Errorf("expected a removed router to stop notifying the app, got %d watchers", n)
+	}
+	app.UseRouter("/api", api)
+	if n := len(api.watchers); n != 1 {
+		t.Errorf("expected a remounted router to notify the app once, got %d watchers", n)
+	}
+
+	v1 := NewRouter()
+	api.UseRouter("/v1", v1)
+	api.RemoveRouter("/v1")
+	if n := len(v1.watchers); n != 0 {
+		t.Errorf("expected a router removed from a router to stop notifying it, got %d watchers", n)
+	}
+}
+
+func TestApp_RuntimeRegistration_InFlightAndConcurrent(t *testing.T) {
+	app := NewServer()
+	started, release := make(chan struct{}), make(chan struct{})
+	app.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		fmt.Fprint(w, "done")
+	})
+
+	slow := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		app.ServeHTTP(slow, httptest.NewRequest("GET", "/slow", nil))
+		close(finished)
+	}()
+	<-started
+	app.RemoveRoute("GET", "/slow")
+
+	stop := make(chan struct{})
+	readers := make(chan struct{})
+	go func() {
+		defer close(readers)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/dyn/3", nil))
+				app.Routes()
+			}
+		}
+	}()
+	api := NewRouter()
+	app.UseRouter("/api", api)
+	writers := make(chan struct{})
+	go func() {
+		defer close(writers)
+		for i := range 20 {
+			api.Get(fmt.Sprintf("/dyn/%d", i), func(w http.ResponseWriter, r *http.Request) {}).Name(fmt.Sprintf("api.dyn.%d", i)).Tag("dyn")
+		}
+	}()
+	for i := range 20 {
+		path := fmt.Sprintf("/dyn/%d", i)
+		app.Get(path, func(w http.ResponseWriter, r *http.Request) {}).Name("dyn." + path).Tag("dyn")
+		if i%2 == 0 {
+			app.RemoveRoute("GET", path)
+		}
+	}
+	<-writers
+	close(stop)
+	<-readers
+
+	close(release)
+	<-finished
+	if slow.Code != http.StatusOK || slow.Body.String() != "done" {
+		t.Errorf("expected the in-flight request to finish, got %d %q", slow.Code, slow.Body.String())
+	}
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/dyn/3", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for /dyn/3, got %d", rr.Code)
+	}
+}
+
+func TestApp_Group(t *testing.T) {
+	app := NewServer()
+	var trail []string
+	mark := func(name string) Middleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				trail = append(trail, name)
+				next(w, r)
+			}
+		}
+	}
+
+	api := NewRouter()
+	api.Use(mark("api"))
+	api.Tag("api")
+	app.UseRouter("/api", api)
+
+	api.Group("/admin", func(g *Router) {
+		g.Tag("admin")
+		g.RequireAuth(func(r *http.Request) bool { return r.Header.Get("X-Admin") == "yes" })
+		g.Get("/stats", func(w http.ResponseWriter, r *http.Request) {
+			trail = append(trail, "handler")
+		}).Tag("stats")
+		g.Group("/slow", func(g *Router) {
+			g.Timeout(10 * time.Millisecond)
+			g.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			})
+		})
+	}, mark("admin"))
+	api.Group("", func(g *Router) {
+		g.Get("/health", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	app.Group("", func(g *Router) {
+		g.Get("/public", func(w http.ResponseWriter, r *http.Request) {})
+	}, mark("public"))
+	app.Group("", func(g *Router) {
+		g.Get("/internal", func(w http.ResponseWriter, r *http.Request) {})
+	}, mark("internal"))
+
+	serve := func(path string, admin bool) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if admin {
+			req.Header.Set("X-Admin", "yes")
+		}
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := serve("/api/admin/stats", false); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without auth, got %d", code)
+	}
+	trail = nil
+	if code := serve("/api/admin/stats", true); code != http.StatusOK {
+		t.Errorf("expected 200 with auth, got %d", code)
+	}
+	if got := strings.Join(trail, ","); got != "api,admin,handler" {
+		t.Errorf("expected middleware order api,admin,handler, got %s", got)
+	}
+	req := httptest.NewRequest("GET", "/api/admin/slow/", nil)
+	req.Header.Set("X-Admin", "yes")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Content-Type") != ProblemContentType {
+		t.Errorf("expected a 503 problem after the group timeout, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if code := serve("/api/admin/stats", true); code != http.StatusOK {
+		t.Errorf("expected 200 within the group timeout, got %d", code)
+	}
+	trail = nil
+	if code := serve("/public", false); code != http.StatusOK || strings.Join(trail, ",") != "public" {
+		t.Errorf("expected 200 behind the public group middleware, got %d %v", code, trail)
+	}
+	trail = nil
+	if code := serve("/internal", false); code != http.StatusOK || strings.Join(trail, ",") != "internal" {
+		t.Errorf("expected 200 behind the second unprefixed group middleware, got %d %v", code, trail)
+	}
+	if err := app.Validate(); err != nil {
+		t.Errorf("expected unprefixed groups to validate, got %v", err)
+	}
+
+	infos := map[string]RouteInfo{}
+	for _, ri := range app.Routes() {
+		infos[ri.Path] = ri
+	}
+	if got := infos["/api/admin/stats"].Tags; strings.Join(got, ",") != "api,admin,stats" {
+		t.Errorf("expected tags api,admin,stats, got %v", got)
+	}
+	if got := infos["/api/admin/slow/"].Timeout; got != 10*time.Millisecond {
+		t.Errorf("expected a 10ms timeout, got %v", got)
+	}
+	if got := infos["/api/admin/stats"].Mounts; strings.Join(got, ",") != "/api,/admin" {
+		t.Errorf("expected mounts /api,/admin, got %v", got)
+	}
+	if got := infos["/api/health"].Mounts; strings.Join(got, ",") != "/api" {
+		t.Errorf("expected mounts /api for a nested unprefixed group, got %v", got)
+	}
+
+	app.Group("", func(g *Router) {
+		g.NotFound(func(w http.ResponseWriter, r *http.Request) {})
+	})
+	if err := app.Validate(); err == nil || !strings.Contains(err.Error(), "without a prefix") {
+		t.Errorf("expected a not-found handler on an unprefixed group to be rejected, got %v", err)
+	}
+}
+
+func showUser(c *Ctx) error {
+	if c.Query("format") == "text" {
+		return c.Text(http.StatusOK, "user "+c.Param("id"))
+	}
+	return c.JSON(http.StatusOK, map[string]string{"id": c.Param("id")})
+}
+
+func TestCtxHandler(t *testing.T) {
+	app := NewServer()
+	app.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Middleware", "yes")
+			next(w, r)
+		}
+	})
+	app.Get("/users/{id}", CtxHandler(showUser)).Describe(showUser)
+	app.Delete("/users/{id}", CtxHandler(func(c *Ctx) error { return c.NoContent() }))
+	app.Get("/old", CtxHandler(func(c *Ctx) error { return c.Redirect(http.StatusMovedPermanently, "/new") }))
+	app.Get("/fail", CtxHandler(func(c *Ctx) error { return errors.New("boom") }))
+	app.Get("/plain", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		method, path string
+		code         int
+		body, ctype  string
+	}{
+		{"GET", "/users/7", http.StatusOK, `{"id":"7"}` + "\n", "application/json; charset=utf-8"},
+		{"GET", "/users/7?format=text", http.StatusOK, "user 7", "text/plain; charset=utf-8"},
+		{"DELETE", "/users/7", http.StatusNoContent, "", ""},
+		{"GET", "/old", http.StatusMovedPermanently, "", ""},
+		{"GET", "/fail", http.StatusInternalServerError, `{"instance":"/fail","status":500,"title":"Internal Server Error"}` + "\n", "application/problem+json"},
+		{"GET", "/plain", http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		if rr.Code != tt.code {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.code, rr.Code)
+		}
+		if tt.body != "" && rr.Body.String() != tt.body {
+			t.Errorf("%s %s: expected body %q, got %q", tt.method, tt.path, tt.body, rr.Body.String())
+		}
+		if tt.ctype != "" && rr.Header().Get("Content-Type") != tt.ctype {
+			t.Errorf("%s %s: expected Content-Type %q, got %q", tt.method, tt.path, tt.ctype, rr.Header().Get("Content-Type"))
+		}
+		if rr.Header().Get("X-Middleware") != "yes" {
+			t.Errorf("%s %s: expected the middleware to run", tt.method, tt.path)
+		}
+	}
+
+	for _, ri := range app.Routes() {
+		if ri.Path == "/users/{id}" && ri.Method == "GET" && !strings.HasSuffix(ri.Handler, ".showUser") {
+			t.Errorf("expected the route to be listed as showUser, got %s", ri.Handler)
+		}
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	app := NewServer()
+	errMissing := &HTTPError{Status: http.StatusNotFound, Code: "user_not_found", Message: "no such user", Details: map[string]string{"id": "7"}}
+	app.Get("/users/{id}", Handler(func(w http.ResponseWriter, r *http.Request) error {
+		return fmt.Errorf("loading user: %w", errMissing)
+	}))
+	app.Get("/crash", Handler(func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("database is down")
+	}))
+	app.Get("/ok", Handler(func(w http.ResponseWriter, r *http.Request) error {
+		w.Write([]byte("fine"))
+		return nil
+	}))
+
+	admin := NewRouter()
+	admin.ErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		http.Error(w, "admin: "+err.Error(), http.StatusTeapot)
+	})
+	admin.Get("/fail", CtxHandler(func(c *Ctx) error { return errMissing }))
+	admin.Group("/inner", func(g *Router) {
+		g.Get("/fail", CtxHandler(func(c *Ctx) error { return errMissing }))
+	})
+	app.UseRouter("/admin", admin)
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/users/7", http.StatusNotFound, `{"code":"user_not_found","detail":"no such user","details":{"id":"7"},"instance":"/users/7","status":404,"title":"Not Found"}` + "\n"},
+		{"/crash", http.StatusInternalServerError, `{"instance":"/crash","status":500,"title":"Internal Server Error"}` + "\n"},
+		{"/ok", http.StatusOK, "fine"},
+		{"/admin/fail", http.StatusTeapot, "admin: 404 no such user\n"},
+		{"/admin/inner/fail", http.StatusTeapot, "admin: 404 no such user\n"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+		if rr.Code != tt.code || rr.Body.String() != tt.body {
+			t.Errorf("GET %s: expected %d %q, got %d %q", tt.path, tt.code, tt.body, rr.Code, rr.Body.String())
+		}
+	}
+
+	app.ErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		http.Error(w, "app: "+err.Error(), http.StatusBadGateway)
+	})
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/crash", nil))
+	if rr.Code != http.StatusBadGateway || rr.Body.String() != "app: database is down\n" {
+		t.Errorf("expected the app error handler, got %d %q", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/fail", nil))
+	if rr.Code != http.StatusTeapot {
+		t.Errorf("expected the router error handler to win, got %d", rr.Code)
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	app := NewServer()
+	app.Get("/users/{id:int}", func(w http.ResponseWriter, r *http.Request) {})
+	app.Post("/upload", Handler(func(w http.ResponseWriter, r *http.Request) error {
+		_, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 4))
+		return err
+	}))
+	app.Get("/out-of-stock", Handler(func(w http.ResponseWriter, r *http.Request) error {
+		return fmt.Errorf("ordering: %w", &Problem{
+			Type:       "https://example.com/probs/out-of-stock",
+			Title:      "Out of stock",
+			Status:     http.StatusConflict,
+			Detail:     "item 12 is out of stock",
+			Instance:   "/orders/7",
+			Extensions: map[string]any{"item": 12, "status": "ignored"},
+		})
+	}))
+
+	tests := []struct {
+		method, path, body string
+		want               map[string]any
+	}{
+		{"GET", "/nope", "", map[string]any{"status": 404.0, "title": "Not Found", "instance": "/nope"}},
+		{"DELETE", "/users/1", "", map[string]any{"status": 405.0, "title": "Method Not Allowed"}},
+		{"GET", "/users/x", "", map[string]any{"status": 400.0, "title": "Bad Request"}},
+		{"POST", "/upload", "too large", map[string]any{"status": 413.0, "title": "Request Entity Too Large"}},
+		{"GET", "/out-of-stock", "", map[string]any{
+			"type":     "https://example.com/probs/out-of-stock",
+			"title":    "Out of stock",
+			"status":   409.0,
+			"detail":   "item 12 is out of stock",
+			"instance": "/orders/7",
+			"item":     12.0,
+		}},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if ct := rr.Header().Get("Content-Type"); ct != ProblemContentType {
+			t.Errorf("%s %s: expected Content-Type %s, got %q", tt.method, tt.path, ProblemContentType, ct)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s %s: invalid JSON %q: %v", tt.method, tt.path, rr.Body.String(), err)
+		}
+		if int(got["status"].(float64)) != rr.Code {
+			t.Errorf("%s %s: status member %v doesn't match response status %d", tt.method, tt.path, got["status"], rr.Code)
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("%s %s: expected %s = %v, got %v", tt.method, tt.path, k, v, got[k])
+			}
+		}
+	}
+}
+
+type bindInput struct {
+	ID      int      `path:"id" validate:"min=1"`
+	Page    int      `query:"page"`
+	Tags    []string `query:"tag"`
+	TraceID string   `header:"X-Trace-Id"`
+	Email   string   `json:"email" validate:"required,email"`
+	Name    string   `json:"name" form:"name" validate:"required,max=5"`
+	Role    string   `json:"role" validate:"oneof=admin user"`
+	Address struct {
+		City string `json:"city" validate:"required"`
+	} `json:"address"`
+}
+
+func TestBind(t *testing.T) {
+	var got bindInput
+	app := NewServer()
+	app.Post("/users/{id}", CtxHandler(func(c *Ctx) error {
+		got = bindInput{}
+		if err := c.Bind(&got); err != nil {
+			return err
+		}
+		return c.NoContent()
+	}))
+
+	newRequest := func(path, ctype, body string) *http.Request {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if ctype != "" {
+			r.Header.Set("Content-Type", ctype)
+		}
+		return r
+	}
+
+	r := newRequest("/users/7?page=2&tag=a&tag=b", "application/json",
+		`{"email":"ana@example.com","name":"Ana","role":"admin","address":{"city":"Lima"}}`)
+	r.Header.Set("X-Trace-Id", "abc")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, r)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rr.Code, rr.Body)
+	}
+	if got.ID != 7 || got.Page != 2 || len(got.Tags) != 2 || got.Tags[1] != "b" || got.TraceID != "abc" ||
+		got.Email != "ana@example.com" || got.Name != "Ana" || got.Address.City != "Lima" {
+		t.Errorf("unexpected binding: %+v", got)
+	}
+
+	tests := []struct {
+		name, path, ctype, body string
+		code                    int
+		fields                  []string
+	}{
+		{"form", "/users/1", "application/x-www-form-urlencoded", "name=Bo&email=bo%40example.com&address.city=Oslo", http.StatusNoContent, nil},
+		{"invalid fields", "/users/0?page=x", "application/json", `{"email":"nope","name":"Alexander","role":"root"}`, http.StatusBadRequest,
+			[]string{"page:query:type", "id:path:min", "email:body:email", "name:body:max", "role:body:oneof", "address.city:body:required"}},
+		{"wrong JSON type", "/users/1", "application/json", `{"email":"a@b.co","name":1,"address":{"city":"x"}}`, http.StatusBadRequest,
+			[]string{"name:body:type"}},
+		{"every JSON type error", "/users/1", "application/json", `{"email":1,"name":true,"address":{"city":2}}`, http.StatusBadRequest,
+			[]string{"email:body:type", "name:body:type", "address.city:body:type"}},
+		{"form required", "/users/1", "application/x-www-form-urlencoded", "email=bo%40example.com&address.city=Oslo", http.StatusBadRequest,
+			[]string{"name:form:required"}},
+		{"malformed JSON", "/users/1", "application/json", `{"email":`, http.StatusBadRequest, nil},
+		{"unsupported type", "/users/1", "text/csv", "a,b", http.StatusUnsupportedMediaType, nil},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, newRequest(tt.path, tt.ctype, tt.body))
+		if rr.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.code, rr.Code, rr.Body)
+			continue
+		}
+		if tt.fields == nil {
+			continue
+		}
+		var problem struct {
+			Errors []FieldError `json:"errors"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("%s: invalid JSON %q: %v", tt.name, rr.Body.String(), err)
+		}
+		fields := []string{}
+		for _, f := range problem.Errors {
+			fields = append(fields, f.Field+":"+f.In+":"+f.Rule)
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: expected field errors %v, got %v", tt.name, tt.fields, fields)
+		}
+	}
+}
+
+func TestBind_BodyCannotSetTaggedFields(t *testing.T) {
+	var dst struct {
+		ID      int    `path:"id"`
+		Page    int    `query:"page"`
+		IsAdmin bool   `header:"X-Admin"`
+		Name    string `json:"name"`
+	}
+	dst.Page = 1
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"ID":7,"Page":9,"IsAdmin":true,"name":"Ana"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if err := Bind(r, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.ID != 0 || dst.Page != 1 || dst.IsAdmin || dst.Name != "Ana" {
+		t.Errorf("expected the body to fill only name, got %+v", dst)
+	}
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader(`{"ID":"abc","Page":[],"IsAdmin":"yes","name":"Bo"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if err := Bind(r, &dst); err != nil {
+		t.Errorf("expected the mistyped tagged members to be ignored, got %v", err)
+	}
+}
+
+func TestBind_Multipart(t *testing.T) {
+	var body strings.Builder
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "cat")
+	fw, _ := mw.CreateFormFile("photo", "cat.png")
+	fw.Write([]byte("png"))
+	mw.Close()
+
+	var dst struct {
+		Title string                `form:"title" validate:"required"`
+		Photo *multipart.FileHeader `form:"photo" validate:"required"`
+	}
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body.String()))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	if err := Bind(r, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Title != "cat" || dst.Photo == nil || dst.Photo.Filename != "cat.png" {
+		t.Errorf("unexpected binding: %+v", dst)
+	}
+
+	if err := Bind(r, dst); err == nil {
+		t.Error("expected an error binding into a non-pointer")
+	}
+}
+
+type getItemIn struct {
+	ID     int    `path:"id" validate:"min=1"`
+	Locale string `header:"Accept-Language"`
+}
+
+type item struct {
+	ID     int    `json:"id" xml:"id"`
+	Locale string `json:"locale" xml:"locale"`
+}
+
+func getItem(ctx context.Context, in getItemIn) (item, error) {
+	if in.ID == 404 {
+		return item{}, &HTTPError{Status: http.StatusNotFound, Message: "no such item"}
+	}
+	return item{ID: in.ID, Locale: in.Locale}, nil
+}
+
+func TestTyped(t *testing.T) {
+	app := NewServer()
+	HandleTyped(&app, http.MethodGet, "/items/{id}", getItem)
+	app.Post("/echo", Typed(func(ctx context.Context, in []string) (string, error) {
+		return strings.Join(in, " "), nil
+	}))
+	app.Delete("/items/{id}", Typed(func(ctx context.Context, in getItemIn) (struct{}, error) {
+		return struct{}{}, nil
+	}))
+
+	tests := []struct {
+		method, path, accept, body string
+		code                       int
+		ctype, want                string
+	}{
+		{"GET", "/items/7", "", "", http.StatusOK, "application/json; charset=utf-8", `{"id":7,"locale":"es"}` + "\n"},
+		{"GET", "/items/7", "application/xml", "", http.StatusOK, "application/xml; charset=utf-8", `<item><id>7</id><locale>es</locale></item>`},
+		{"GET", "/items/7", "text/html, application/*;q=0.5", "", http.StatusOK, "application/json; charset=utf-8", `{"id":7,"locale":"es"}` + "\n"},
+		{"GET", "/items/7", "text/plain", "", http.StatusNotAcceptable, ProblemContentType, ""},
+		{"GET", "/items/0", "", "", http.StatusBadRequest, ProblemContentType, ""},
+		{"GET", "/items/404", "", "", http.StatusNotFound, ProblemContentType, ""},
+		{"POST", "/echo", "text/plain", `["a","b"]`, http.StatusOK, "text/plain; charset=utf-8", "a b"},
+		{"POST", "/echo", "", `["a","b"]`, http.StatusOK, "application/json; charset=utf-8", `"a b"` + "\n"},
+		{"POST", "/echo", "", `{"a":1}`, http.StatusBadRequest, ProblemContentType, ""},
+		{"DELETE", "/items/7", "", "", http.StatusNoContent, "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		r.Header.Set("Accept-Language", "es")
+		r.Header.Set("Accept", tt.accept)
+		if tt.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, r)
+		if rr.Code != tt.code {
+			t.Errorf("%s %s (Accept %q): expected %d, got %d: %s", tt.method, tt.path, tt.accept, tt.code, rr.Code, rr.Body)
+			continue
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != tt.ctype {
+			t.Errorf("%s %s (Accept %q): expected Content-Type %q, got %q", tt.method, tt.path, tt.accept, tt.ctype, ct)
+		}
+		if tt.want != "" && rr.Body.String() != tt.want {
+			t.Errorf("%s %s (Accept %q): expected body %q, got %q", tt.method, tt.path, tt.accept, tt.want, rr.Body.String())
+		}
+	}
+
+	routes := app.Routes()
+	if routes[0].Input != reflect.TypeFor[getItemIn]() || routes[0].Output != reflect.TypeFor[item]() {
+		t.Errorf("expected getItemIn -> item, got %v -> %v", routes[0].Input, routes[0].Output)
+	}
+	if routes[1].Input != nil || routes[1].Output != nil {
+		t.Errorf("expected no types for a route registered with Typed, got %v -> %v", routes[1].Input, routes[1].Output)
+	}
+	if !strings.HasSuffix(routes[0].Handler, ".getItem") {
+		t.Errorf("expected handler getItem, got %s", routes[0].Handler)
+	}
+	if !strings.Contains(routes[0].Source, "cafe_test.go") {
+		t.Errorf("expected the source of the HandleTyped call, got %s", routes[0].Source)
+	}
+}
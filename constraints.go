@@ -0,0 +1,137 @@
+package cafe
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*** Definitions ***/
+
+// paramConstraint restricts the values a path wildcard accepts, written as
+// {name:int}, {name:uuid}, {name:open|closed} or {name:[a-z]+}.
+type paramConstraint struct {
+	name  string
+	match func(value string) bool
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// builtinConstraints are the named constraints; anything else is a regular
+// expression that must match the whole value.
+var builtinConstraints = map[string]func(string) bool{
+	"int": func(v string) bool {
+		_, err := strconv.ParseInt(v, 10, 64)
+		return err == nil
+	},
+	"uint": func(v string) bool {
+		_, err := strconv.ParseUint(v, 10, 64)
+		return err == nil
+	},
+	"uuid": uuidPattern.MatchString,
+	"alpha": func(v string) bool {
+		return v != "" && strings.IndexFunc(v, func(r rune) bool {
+			return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z')
+		}) < 0
+	},
+}
+
+/*** Parsing ***/
+
+// parseConstraints strips constraints from the wildcards of path, returning
+// the plain ServeMux path and the compiled constraints.
+func parseConstraints(path string) (string, []paramConstraint, error) {
+	if !strings.Contains(path, ":") {
+		return path, nil, nil
+	}
+
+	var sb strings.Builder
+	constraints := []paramConstraint{}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '{' {
+			sb.WriteByte(path[i])
+			continue
+		}
+		end := closingBrace(path, i)
+		if end < 0 {
+			return "", nil, fmt.Errorf("unbalanced braces in %q", path)
+		}
+		name, expr, ok := strings.Cut(path[i+1:end], ":")
+		sb.WriteString("{" + name + "}")
+		i = end
+		if !ok {
+			continue
+		}
+
+		match, ok := builtinConstraints[expr]
+		if !ok {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return "", nil, fmt.Errorf("bad constraint for {%s}: %w", name, err)
+			}
+			match = re.MatchString
+		}
+		constraints = append(constraints, paramConstraint{
+			name:  strings.TrimSuffix(name, "..."),
+			match: match,
+		})
+	}
+	return sb.String(), constraints, nil
+}
+
+// closingBrace returns the index of the brace closing the one at start,
+// allowing nested braces such as regexp repetitions.
+func closingBrace(path string, start int) int {
+	depth := 0
+	for i := start; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+/*** Checking ***/
+
+// checkConstraints runs handler only when every constrained wildcard
+// matches, and invalid otherwise.
+func checkConstraints(handler, invalid http.HandlerFunc, constraints []paramConstraint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range constraints {
+			if !c.match(r.PathValue(c.name)) {
+				invalid(w, r)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+func invalidParam(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, NewProblem(http.StatusBadRequest, "a path value does not match its route constraint"))
+}
+
+/*** Accessors ***/
+
+// PathInt returns the path wildcard name as an int.
+func PathInt(r *http.Request, name string) (int, error) {
+	return strconv.Atoi(r.PathValue(name))
+}
+
+// PathInt64 returns the path wildcard name as an int64.
+func PathInt64(r *http.Request, name string) (int64, error) {
+	return strconv.ParseInt(r.PathValue(name), 10, 64)
+}
+
+// PathUint returns the path wildcard name as a uint64.
+func PathUint(r *http.Request, name string) (uint64, error) {
+	return strconv.ParseUint(r.PathValue(name), 10, 64)
+}
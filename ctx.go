@@ -0,0 +1,100 @@
+package cafe
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+/*** Definitions ***/
+
+// Ctx wraps the response writer and request of a handler written with
+// CtxHandler, with helpers for reading the request and writing common
+// responses.
+type Ctx struct {
+	Writer  http.ResponseWriter
+	Request *http.Request
+	query   url.Values
+}
+
+/*** Adapters ***/
+
+// CtxHandler adapts fn into a handler usable with every route helper and
+// middleware. A non-nil error from fn is passed to the error handler in
+// scope for the route, like with Handler. Route introspection names the
+// adapter; see Route.Describe.
+func CtxHandler(fn func(c *Ctx) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := fn(&Ctx{Writer: w, Request: r}); err != nil {
+			handleError(w, r, err)
+		}
+	}
+}
+
+/*** Request ***/
+
+// Context returns the request context.
+func (c *Ctx) Context() context.Context {
+	return c.Request.Context()
+}
+
+// Param returns the value of the path wildcard name.
+func (c *Ctx) Param(name string) string {
+	return c.Request.PathValue(name)
+}
+
+// Query returns the first value of the query parameter name. The query
+// string is parsed once per Ctx.
+func (c *Ctx) Query(name string) string {
+	if c.query == nil {
+		c.query = c.Request.URL.Query()
+	}
+	return c.query.Get(name)
+}
+
+// Bind fills and validates the struct dst points to from the request, as
+// Bind does.
+func (c *Ctx) Bind(dst any) error {
+	return Bind(c.Request, dst)
+}
+
+/*** Response ***/
+
+// Header returns the response headers, to be set before writing.
+func (c *Ctx) Header() http.Header {
+	return c.Writer.Header()
+}
+
+// Status writes a response with status and no body.
+func (c *Ctx) Status(status int) error {
+	c.Writer.WriteHeader(status)
+	return nil
+}
+
+// NoContent writes a 204 No Content response.
+func (c *Ctx) NoContent() error {
+	return c.Status(http.StatusNoContent)
+}
+
+// JSON writes v encoded as JSON with status.
+func (c *Ctx) JSON(status int, v any) error {
+	c.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.Writer.WriteHeader(status)
+	return json.NewEncoder(c.Writer).Encode(v)
+}
+
+// Text writes s as plain text with status.
+func (c *Ctx) Text(status int, s string) error {
+	c.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	c.Writer.WriteHeader(status)
+	_, err := c.Writer.Write([]byte(s))
+	return err
+}
+
+// Redirect redirects the request to url, which may be relative to the
+// request path, with status, e.g. http.StatusSeeOther.
+func (c *Ctx) Redirect(status int, url string) error {
+	http.Redirect(c.Writer, c.Request, url, status)
+	return nil
+}
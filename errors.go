@@ -0,0 +1,139 @@
+package cafe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+/*** Definitions ***/
+
+// HTTPError is an error with the response it should produce. Handlers
+// return it, possibly wrapped, to choose the status and body rendered by the
+// error handler.
+type HTTPError struct {
+	// Status is the HTTP status code of the response.
+	Status int
+	// Code is a stable, machine-readable identifier, e.g. "user_not_found".
+	Code string
+	// Message is a human-readable description, safe to show to clients.
+	Message string
+	// Details carries extra data for clients, e.g. the invalid fields.
+	Details any
+	// Err is the underlying cause. It is not shown to clients.
+	Err error
+}
+
+// ErrorHandlerFunc renders an error returned by a handler.
+type ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request, err error)
+
+type errorHandlerKey struct{}
+
+func (e *HTTPError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.Status)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %v", e.Status, msg, e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Status, msg)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+/*** Aggregation ***/
+
+// ErrorHandler sets the handler rendering the errors returned by handlers
+// adapted with Handler, CtxHandler and the like. The default is
+// DefaultErrorHandler.
+func (a *App) ErrorHandler(handler ErrorHandlerFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.errorHandler = handler
+	a.reload()
+}
+
+// ErrorHandler sets the error handler for every route below the router,
+// overriding the one of the app and of the routers it is mounted under.
+func (r *Router) ErrorHandler(handler ErrorHandlerFunc) {
+	r.mu.Lock()
+	r.options.errorHandler = handler
+	r.mu.Unlock()
+	r.changed()
+}
+
+/*** Adapters ***/
+
+// Handler adapts a handler that returns an error into one usable with every
+// route helper and middleware. A non-nil error is passed to the error
+// handler in scope for the route.
+func Handler(fn func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := fn(w, r); err != nil {
+			handleError(w, r, err)
+		}
+	}
+}
+
+func handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if handler, ok := r.Context().Value(errorHandlerKey{}).(ErrorHandlerFunc); ok {
+		handler(w, r, err)
+		return
+	}
+	DefaultErrorHandler(w, r, err)
+}
+
+// withErrorHandler puts handler in scope for the requests served by next.
+func withErrorHandler(next http.HandlerFunc, handler ErrorHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), errorHandlerKey{}, handler)))
+	}
+}
+
+/*** Rendering ***/
+
+// DefaultErrorHandler renders err as RFC 9457 problem details. A Problem in
+// err's chain is written as is, and an HTTPError gives the status and
+// detail, with its code and details as extension members. A ValidationError
+// is a 400 listing its fields as "errors". A body over the
+// http.MaxBytesReader limit is a 413; any other error is a 500 whose cause
+// is not disclosed.
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	WriteProblem(w, r, problemOf(err))
+}
+
+func problemOf(err error) *Problem {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		return problem
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		problem = NewProblem(httpErr.Status, httpErr.Message)
+		if httpErr.Code != "" || httpErr.Details != nil {
+			problem.Extensions = map[string]any{}
+		}
+		if httpErr.Code != "" {
+			problem.Extensions["code"] = httpErr.Code
+		}
+		if httpErr.Details != nil {
+			problem.Extensions["details"] = httpErr.Details
+		}
+		return problem
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		problem = NewProblem(http.StatusBadRequest, "the request has invalid fields")
+		problem.Extensions = map[string]any{"errors": validationErr.Fields}
+		return problem
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return NewProblem(http.StatusRequestEntityTooLarge, fmt.Sprintf("the request body is larger than %d bytes", maxBytesErr.Limit))
+	}
+	return NewProblem(http.StatusInternalServerError, "")
+}
@@ -0,0 +1,188 @@
+package cafe
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*** Definitions ***/
+
+// timeoutWriter buffers the response of a handler run by withTimeout, so it
+// can be dropped if the deadline passes first.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+	timedOut    bool
+}
+
+// routeOptions are applied by a router to every route below it, on top of
+// the options of the routers it is mounted under.
+type routeOptions struct {
+	timeout      time.Duration
+	tags         []string
+	auth         []func(r *http.Request) bool
+	errorHandler ErrorHandlerFunc
+}
+
+/*** Aggregation ***/
+
+// Group mounts a new router at path, set up by fn, whose routes run behind
+// mws. Like any mounted router, the group inherits the middlewares and
+// options of everything it is mounted under. path may be empty to share
+// middlewares without a prefix.
+func (a *App) Group(path string, fn func(g *Router), mws ...Middleware) *Router {
+	g := newGroup(fn, mws)
+	a.mountRouter(mountedRouter{path: path, router: g, source: callerSite(1)})
+	return g
+}
+
+// Group mounts a new router below r at path, set up by fn. See App.Group.
+func (r *Router) Group(path string, fn func(g *Router), mws ...Middleware) *Router {
+	g := newGroup(fn, mws)
+	r.mountRouter(mountedRouter{path: path, router: g, source: callerSite(1)})
+	return g
+}
+
+func newGroup(fn func(g *Router), mws []Middleware) *Router {
+	g := NewRouter()
+	g.Use(mws...)
+	fn(g)
+	return g
+}
+
+/*** Options ***/
+
+// Timeout bounds how long every route below the router may run. Slower
+// requests get a 503 Service Unavailable and their context is cancelled.
+// Nested timeouts all apply, so the shortest one wins.
+func (r *Router) Timeout(d time.Duration) {
+	r.mu.Lock()
+	r.options.timeout = d
+	r.mu.Unlock()
+	r.changed()
+}
+
+// Tag adds tags to every route below the router, listed by App.Routes.
+func (r *Router) Tag(tags ...string) {
+	r.mu.Lock()
+	r.options.tags = append(r.options.tags, tags...)
+	r.mu.Unlock()
+	r.changed()
+}
+
+// RequireAuth answers requests to every route below the router with a 401
+// Unauthorized unless allow reports true. It runs behind the router
+// middlewares, so one of them can authenticate the request first.
+func (r *Router) RequireAuth(allow func(r *http.Request) bool) {
+	r.mu.Lock()
+	r.options.auth = append(r.options.auth, allow)
+	r.mu.Unlock()
+	r.changed()
+}
+
+/*** Assembly ***/
+
+func (o routeOptions) apply(rt route) route {
+	if o.errorHandler != nil {
+		rt.handler = withErrorHandler(rt.handler, o.errorHandler)
+	}
+	if len(o.auth) > 0 {
+		rt.handler = requireAuth(rt.handler, slices.Clone(o.auth))
+	}
+	if o.timeout > 0 {
+		rt.handler = withTimeout(rt.handler, o.timeout)
+		if rt.timeout == 0 || o.timeout < rt.timeout {
+			rt.timeout = o.timeout
+		}
+	}
+	rt.tags = append(slices.Clone(o.tags), rt.tags...)
+	return rt
+}
+
+func requireAuth(handler http.HandlerFunc, checks []func(r *http.Request) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, allow := range checks {
+			if !allow(r) {
+				WriteProblem(w, r, NewProblem(http.StatusUnauthorized, ""))
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// withTimeout runs handler with a deadline of d, like http.TimeoutHandler,
+// but answers a 503 problem when the deadline passes first. The response is
+// buffered until the handler returns.
+func withTimeout(handler http.HandlerFunc, d time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{header: http.Header{}}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			handler(tw, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			maps.Copy(w.Header(), tw.header)
+			if !tw.wroteHeader {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			WriteProblem(w, r, NewProblem(http.StatusServiceUnavailable, "the request timed out"))
+		}
+	}
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.status, tw.wroteHeader = http.StatusOK, true
+	}
+	return tw.body.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.status, tw.wroteHeader = status, true
+}
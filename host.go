@@ -0,0 +1,139 @@
+package cafe
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/*** Definitions ***/
+
+// hostRouter serves a router for requests whose Host matches host. Labels
+// written as {name} match any single label and are exposed as path values.
+type hostRouter struct {
+	host        string
+	labels      []string
+	constraints []paramConstraint
+	mount       mountedRouter
+	mux         Matcher
+	notFound    Matcher
+}
+
+/*** Aggregation ***/
+
+// Host serves ro for requests to host instead of the default routes, e.g.
+// "api.example.com". A label like {tenant} in "{tenant}.example.com" matches
+// any subdomain and is available as r.PathValue("tenant"). Exact hosts are
+// tried before wildcard ones.
+func (a *App) Host(host string, ro *Router) {
+	source := callerSite(1)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	clean, constraints, err := parseConstraints(host)
+	clean = strings.ToLower(clean)
+	if err == nil {
+		err = checkHost(clean)
+	}
+	if err != nil {
+		a.errs = append(a.errs, &RouteError{Pattern: "host " + host, Source: source, Reason: "is invalid: " + err.Error()})
+		return
+	}
+
+	for _, h := range a.hosts {
+		if h.host == clean {
+			a.errs = append(a.errs, &RouteError{
+				Pattern:     "host " + host,
+				Source:      source,
+				Reason:      "duplicates",
+				Other:       "host " + h.host,
+				OtherSource: h.mount.source,
+			})
+			return
+		}
+	}
+	a.hosts = append(a.hosts, hostRouter{
+		host:        clean,
+		labels:      strings.Split(clean, "."),
+		constraints: constraints,
+		mount:       mountedRouter{router: ro, source: source, unwatch: ro.watch(a.routerChanged)},
+	})
+	a.reload()
+}
+
+func checkHost(host string) error {
+	for _, label := range strings.Split(host, ".") {
+		if label == "" {
+			return fmt.Errorf("empty label in %q", host)
+		}
+		if strings.ContainsAny(label, "{}") && !isWildcardLabel(label) {
+			return fmt.Errorf("label %q must be a literal or a single {name}", label)
+		}
+	}
+	return nil
+}
+
+/*** Dispatch ***/
+
+// hostHandler picks the Matcher serving r, setting the values of wildcard
+// host labels on r.
+func (t *routeTable) hostHandler(r *http.Request) http.Handler {
+	mux, _ := t.hostMatchers(r)
+	return mux
+}
+
+// hostMatchers picks the Matchers of r's host: the one serving it and the
+// one holding only its not-found patterns.
+func (t *routeTable) hostMatchers(r *http.Request) (Matcher, Matcher) {
+	if len(t.hosts) == 0 {
+		return t.mux, t.notFound
+	}
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+
+	for _, h := range t.hosts {
+		if h.host == host {
+			return h.mux, h.notFound
+		}
+	}
+	labels := strings.Split(host, ".")
+	for _, h := range t.hosts {
+		if values, ok := h.match(labels); ok {
+			for name, value := range values {
+				r.SetPathValue(name, value)
+			}
+			return h.mux, h.notFound
+		}
+	}
+	return t.mux, t.notFound
+}
+
+func (h hostRouter) match(labels []string) (map[string]string, bool) {
+	if len(labels) != len(h.labels) {
+		return nil, false
+	}
+	values := map[string]string{}
+	for i, label := range h.labels {
+		if !isWildcardLabel(label) {
+			if label != labels[i] {
+				return nil, false
+			}
+			continue
+		}
+		values[strings.Trim(label, "{}")] = labels[i]
+	}
+	for _, c := range h.constraints {
+		if !c.match(values[c.name]) {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+func isWildcardLabel(label string) bool {
+	return len(label) > 2 && label[0] == '{' && label[len(label)-1] == '}' &&
+		!strings.ContainsAny(label[1:len(label)-1], "{}")
+}
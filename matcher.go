@@ -0,0 +1,281 @@
+package cafe
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+/*** Definitions ***/
+
+// Matcher dispatches requests to the handlers registered on it. Patterns use
+// the ServeMux syntax without a host, "[METHOD ]/path", and Handle panics on
+// a pattern it rejects or that conflicts with another one, like ServeMux
+// does. The matched pattern is set as r.Pattern and wildcards are available
+// through r.PathValue.
+type Matcher interface {
+	Handle(pattern string, handler http.Handler)
+	ServeHTTP(w http.ResponseWriter, r *http.Request)
+}
+
+// radixMatcher is a Matcher backed by a tree with one level per path segment.
+// Each segment prefers a literal to a {name} wildcard and a wildcard to a
+// catch-all, and a pattern with a method to a method-less one, backtracking
+// when a branch has no handler for the request.
+type radixMatcher struct {
+	root radixNode
+}
+
+type radixNode struct {
+	literals map[string]*radixNode
+	wildcard *radixNode
+	rest     *radixNode
+	any      *radixRoute
+	methods  map[string]*radixRoute
+}
+
+type radixRoute struct {
+	pattern string
+	handler http.Handler
+	names   []string
+}
+
+// maxWildcards bounds the wildcards in a pattern, so values are collected in
+// a fixed array instead of an allocated slice.
+const maxWildcards = 32
+
+type radixValues [maxWildcards]string
+
+/*** Options ***/
+
+// WithMatcher replaces http.ServeMux with the Matcher newMatcher returns,
+// e.g. cafe.WithMatcher(cafe.NewRadixMatcher). A new Matcher is created for
+// the default routes, for every host and when validating.
+func WithMatcher(newMatcher func() Matcher) Option {
+	return func(s *settings) {
+		s.newMatcher = newMatcher
+	}
+}
+
+func newServeMux() Matcher {
+	return http.NewServeMux()
+}
+
+// NewRadixMatcher returns a tree-based Matcher. Unlike ServeMux, precedence
+// is decided segment by segment from the left: a literal segment beats a
+// {name} wildcard, which beats a catch-all, so only identical patterns
+// conflict. Matching does not allocate, except to store path values.
+func NewRadixMatcher() Matcher {
+	return &radixMatcher{}
+}
+
+/*** Registration ***/
+
+func (m *radixMatcher) Handle(pattern string, handler http.Handler) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+	path = strings.TrimLeft(path, " ")
+	if !strings.HasPrefix(path, "/") {
+		panic(fmt.Sprintf("cafe: pattern %q: path must start with /", pattern))
+	}
+
+	segments := strings.Split(path[1:], "/")
+	if wildcards(segments) > maxWildcards {
+		panic(fmt.Sprintf("cafe: pattern %q: more than %d wildcards", pattern, maxWildcards))
+	}
+
+	rt := &radixRoute{pattern: pattern, handler: handler}
+	n := &m.root
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch {
+		case last && seg == "":
+			rt.names = append(rt.names, "")
+			n = child(&n.rest)
+		case seg == "{$}":
+			if !last {
+				panic(fmt.Sprintf("cafe: pattern %q: {$} must be the last segment", pattern))
+			}
+			n = n.literal("")
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "...}"):
+			if !last {
+				panic(fmt.Sprintf("cafe: pattern %q: %s must be the last segment", pattern, seg))
+			}
+			rt.names = append(rt.names, checkWildcard(pattern, rt.names, seg[1:len(seg)-4]))
+			n = child(&n.rest)
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			rt.names = append(rt.names, checkWildcard(pattern, rt.names, seg[1:len(seg)-1]))
+			n = child(&n.wildcard)
+		case seg == "" || strings.ContainsAny(seg, "{}"):
+			panic(fmt.Sprintf("cafe: pattern %q: invalid segment %q", pattern, seg))
+		default:
+			lit, err := url.PathUnescape(seg)
+			if err != nil {
+				panic(fmt.Sprintf("cafe: pattern %q: %v", pattern, err))
+			}
+			n = n.literal(lit)
+		}
+	}
+	n.add(method, rt)
+}
+
+// wildcards counts the values a pattern with segments captures, including
+// the anonymous one of a trailing slash.
+func wildcards(segments []string) int {
+	n := 0
+	for i, seg := range segments {
+		if seg != "{$}" && strings.HasPrefix(seg, "{") || seg == "" && i == len(segments)-1 {
+			n++
+		}
+	}
+	return n
+}
+
+func checkWildcard(pattern string, names []string, name string) string {
+	if name == "" || !isIdentifier(name) {
+		panic(fmt.Sprintf("cafe: pattern %q: bad wildcard name %q", pattern, name))
+	}
+	if slices.Contains(names, name) {
+		panic(fmt.Sprintf("cafe: pattern %q: duplicate wildcard name %q", pattern, name))
+	}
+	return name
+}
+
+func isIdentifier(name string) bool {
+	for i, c := range name {
+		if c != '_' && !isLetter(c) && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetter(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c > 0x7f
+}
+
+func child(c **radixNode) *radixNode {
+	if *c == nil {
+		*c = &radixNode{}
+	}
+	return *c
+}
+
+func (n *radixNode) literal(seg string) *radixNode {
+	if n.literals == nil {
+		n.literals = map[string]*radixNode{}
+	}
+	c, ok := n.literals[seg]
+	if !ok {
+		c = &radixNode{}
+		n.literals[seg] = c
+	}
+	return c
+}
+
+func (n *radixNode) add(method string, rt *radixRoute) {
+	other := n.any
+	if method != "" {
+		other = n.methods[method]
+	}
+	if other != nil {
+		panic(fmt.Sprintf("cafe: pattern %q conflicts with pattern %q", rt.pattern, other.pattern))
+	}
+	if method == "" {
+		n.any = rt
+		return
+	}
+	if n.methods == nil {
+		n.methods = map[string]*radixRoute{}
+	}
+	n.methods[method] = rt
+}
+
+/*** Dispatch ***/
+
+func (m *radixMatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var values radixValues
+	rt := m.lookup(r.Method, r.URL.EscapedPath(), &values)
+	if rt == nil {
+		WriteProblem(w, r, NewProblem(http.StatusNotFound, ""))
+		return
+	}
+	r.Pattern = rt.pattern
+	for i, name := range rt.names {
+		if name == "" {
+			continue
+		}
+		value, err := url.PathUnescape(values[i])
+		if err != nil {
+			value = values[i]
+		}
+		r.SetPathValue(name, value)
+	}
+	rt.handler.ServeHTTP(w, r)
+}
+
+// lookup finds the route for method and the escaped path, storing the raw
+// wildcard values in values.
+func (m *radixMatcher) lookup(method, path string, values *radixValues) *radixRoute {
+	if !strings.HasPrefix(path, "/") {
+		return nil
+	}
+	return m.root.match(method, path[1:], values, 0)
+}
+
+// match finds the route for path, the rest of the request path after a
+// slash. n values have been collected so far.
+func (n *radixNode) match(method, path string, values *radixValues, nv int) *radixRoute {
+	seg, next, more := strings.Cut(path, "/")
+	if n.literals != nil {
+		c := n.literals[seg]
+		if c == nil && strings.Contains(seg, "%") {
+			if lit, err := url.PathUnescape(seg); err == nil {
+				c = n.literals[lit]
+			}
+		}
+		if c != nil {
+			if rt := c.matchNext(method, next, more, values, nv); rt != nil {
+				return rt
+			}
+		}
+	}
+	if n.wildcard != nil && seg != "" {
+		values[nv] = seg
+		if rt := n.wildcard.matchNext(method, next, more, values, nv+1); rt != nil {
+			return rt
+		}
+	}
+	if n.rest != nil {
+		if rt := n.rest.route(method); rt != nil {
+			values[nv] = path
+			return rt
+		}
+	}
+	return nil
+}
+
+func (n *radixNode) matchNext(method, next string, more bool, values *radixValues, nv int) *radixRoute {
+	if !more {
+		return n.route(method)
+	}
+	return n.match(method, next, values, nv)
+}
+
+// route returns the handler registered on n for method. GET patterns also
+// match HEAD requests, and method-less ones match every method.
+func (n *radixNode) route(method string) *radixRoute {
+	if rt := n.methods[method]; rt != nil {
+		return rt
+	}
+	if method == http.MethodHead {
+		if rt := n.methods[http.MethodGet]; rt != nil {
+			return rt
+		}
+	}
+	return n.any
+}
@@ -0,0 +1,250 @@
+package cafe
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRadixMatcher_MatchesLikeServeMux(t *testing.T) {
+	patterns := []string{
+		"/",
+		"/{$}",
+		"GET /users/{$}",
+		"GET /users/{id}",
+		"DELETE /users/{id}",
+		"/users/{id}",
+		"GET /users/{id}/posts/{post}",
+		"GET /files/{path...}",
+		"/legacy/",
+		"POST /teams",
+	}
+	requests := []struct{ method, path string }{
+		{"GET", "/"},
+		{"GET", "/nowhere"},
+		{"GET", "/users/"},
+		{"HEAD", "/users/"},
+		{"GET", "/users/42"},
+		{"DELETE", "/users/42"},
+		{"PUT", "/users/42"},
+		{"GET", "/users/42/posts/7"},
+		{"GET", "/users/a%2Fb"},
+		{"GET", "/files/"},
+		{"GET", "/files/a/b.txt"},
+		{"GET", "/legacy/x/y"},
+		{"POST", "/teams"},
+		{"GET", "/teams"},
+	}
+
+	serve := func(m Matcher, method, path string) string {
+		for _, p := range patterns {
+			m.Handle(p, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "%s id=%s post=%s path=%s", r.Pattern, r.PathValue("id"), r.PathValue("post"), r.PathValue("path"))
+			}))
+		}
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr.Body.String()
+	}
+
+	for _, req := range requests {
+		want := serve(http.NewServeMux(), req.method, req.path)
+		got := serve(NewRadixMatcher(), req.method, req.path)
+		if got != want {
+			t.Errorf("%s %s: expected %q, got %q", req.method, req.path, want, got)
+		}
+	}
+}
+
+func TestRadixMatcher_Precedence(t *testing.T) {
+	m := NewRadixMatcher()
+	for _, p := range []string{"GET /users/{id}/posts", "GET /users/new/{tab}", "GET /{path...}"} {
+		m.Handle(p, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.Pattern)
+		}))
+	}
+
+	tests := map[string]string{
+		"/users/new/posts": "GET /users/new/{tab}",
+		"/users/7/posts":   "GET /users/{id}/posts",
+		"/users/new":       "GET /{path...}",
+	}
+	for path, want := range tests {
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Body.String() != want {
+			t.Errorf("GET %s: expected %q, got %q", path, want, rr.Body.String())
+		}
+	}
+}
+
+func TestRadixMatcher_RejectsPatterns(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	m := NewRadixMatcher()
+	m.Handle("GET /users/{id}", h)
+
+	for _, p := range []string{
+		"GET /users/{name}",
+		"users",
+		"/files/{path...}/x",
+		"/a/{$}/b",
+		"/a/{x}/{x}",
+		"/a/{1x}",
+		"/a/b{c}",
+	} {
+		if err := tryHandle(m, p, h); err == nil {
+			t.Errorf("expected %q to be rejected", p)
+		}
+	}
+}
+
+func TestRadixMatcher_LookupDoesNotAllocate(t *testing.T) {
+	m := NewRadixMatcher().(*radixMatcher)
+	for _, p := range benchmarkPatterns() {
+		m.Handle(p, http.NotFoundHandler())
+	}
+
+	var values radixValues
+	allocs := testing.AllocsPerRun(100, func() {
+		if m.lookup("GET", "/r150/42/items/7", &values) == nil {
+			t.Fatal("expected a match")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
+
+func TestApp_WithRadixMatcher(t *testing.T) {
+	app := NewServer(WithMatcher(NewRadixMatcher))
+	app.Get("/users/{id:int}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "user "+r.PathValue("id"))
+	})
+	app.Mount("/legacy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "legacy "+r.URL.Path)
+	}))
+	api := NewRouter()
+	api.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "api not found", http.StatusNotFound)
+	})
+	api.Post("/items", func(w http.ResponseWriter, r *http.Request) {})
+	app.UseRouter("/api", api)
+
+	if err := app.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"GET", "/users/7", http.StatusOK, "user 7"},
+		{"GET", "/users/7/", http.StatusOK, "user 7"},
+		{"GET", "/users/x", http.StatusBadRequest, ""},
+		{"DELETE", "/users/7", http.StatusMethodNotAllowed, ""},
+		{"OPTIONS", "/users/7", http.StatusNoContent, ""},
+		{"GET", "/legacy/a/b", http.StatusOK, "legacy /a/b"},
+		{"GET", "/api/items", http.StatusMethodNotAllowed, ""},
+		{"GET", "/api/nope", http.StatusNotFound, "api not found\n"},
+		{"GET", "/nope", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		if rr.Code != tt.code {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.code, rr.Code)
+		}
+		if tt.body != "" && rr.Body.String() != tt.body {
+			t.Errorf("%s %s: expected body %q, got %q", tt.method, tt.path, tt.body, rr.Body.String())
+		}
+	}
+}
+
+// benchmarkPatterns returns 2,000 patterns shaped like a typical REST
+// service: 200 resources with 10 routes each.
+func benchmarkPatterns() []string {
+	patterns := []string{}
+	for i := range 200 {
+		r := fmt.Sprintf("/r%d", i)
+		patterns = append(patterns,
+			"GET "+r+"/{$}",
+			"POST "+r+"/{$}",
+			"GET "+r+"/{id}",
+			"PUT "+r+"/{id}",
+			"DELETE "+r+"/{id}",
+			"GET "+r+"/{id}/items",
+			"POST "+r+"/{id}/items",
+			"GET "+r+"/{id}/items/{item}",
+			"PATCH "+r+"/{id}/items/{item}",
+			"GET "+r+"/{id}/files/{path...}",
+		)
+	}
+	return patterns
+}
+
+func benchmarkMatcher(b *testing.B, m Matcher) {
+	for _, p := range benchmarkPatterns() {
+		m.Handle(p, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	}
+	paths := []string{"/r0/", "/r57/42", "/r150/42/items/7", "/r199/9/files/a/b/c.txt"}
+	reqs := []*http.Request{}
+	for _, p := range paths {
+		reqs = append(reqs, httptest.NewRequest("GET", p, nil))
+	}
+	w := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.ServeHTTP(w, reqs[i%len(reqs)])
+	}
+}
+
+func BenchmarkMatcher_ServeMux(b *testing.B) {
+	benchmarkMatcher(b, http.NewServeMux())
+}
+
+func BenchmarkMatcher_Radix(b *testing.B) {
+	benchmarkMatcher(b, NewRadixMatcher())
+}
+
+func BenchmarkMatcher_RadixLookup(b *testing.B) {
+	m := NewRadixMatcher().(*radixMatcher)
+	for _, p := range benchmarkPatterns() {
+		m.Handle(p, http.NotFoundHandler())
+	}
+	var values radixValues
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.lookup("GET", "/r150/42/items/7", &values)
+	}
+}
+
+func benchmarkApp(b *testing.B, opts ...Option) {
+	app := NewServer(opts...)
+	for _, p := range benchmarkPatterns() {
+		method, path, _ := strings.Cut(p, " ")
+		app.Handle(method, strings.TrimSuffix(path, "{$}"), func(w http.ResponseWriter, r *http.Request) {})
+	}
+	req := httptest.NewRequest("GET", "/r150/42/items/7", nil)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		app.ServeHTTP(w, req)
+	}
+}
+
+func BenchmarkApp_ServeMux(b *testing.B) {
+	benchmarkApp(b)
+}
+
+func BenchmarkApp_Radix(b *testing.B) {
+	benchmarkApp(b, WithMatcher(NewRadixMatcher))
+}
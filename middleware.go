@@ -0,0 +1,21 @@
+package cafe
+
+import "net/http"
+
+/*** Adapters ***/
+
+// FromHandler adapts standard library style middleware so it can be passed
+// to Use or to the route helpers.
+func FromHandler(mw func(http.Handler) http.Handler) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return mw(next).ServeHTTP
+	}
+}
+
+// Handler returns m in the standard library middleware signature, for use
+// outside cafe.
+func (m Middleware) Handler() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return m(next.ServeHTTP)
+	}
+}
@@ -0,0 +1,77 @@
+package cafe
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*** Mounting ***/
+
+// Mount serves handler for every method on prefix and everything below it,
+// with prefix stripped from the request path. The app middlewares still
+// wrap it.
+func (a *App) Mount(prefix string, handler http.Handler, mws ...Middleware) *Route {
+	return a.addRoute(mountRoute(prefix, handler), mws)
+}
+
+// Mount serves handler for every method on prefix and everything below it,
+// with the full mount path stripped from the request path. The router
+// middlewares, and those of every router above it, still wrap it.
+func (r *Router) Mount(prefix string, handler http.Handler, mws ...Middleware) *Route {
+	return r.addRoute(mountRoute(prefix, handler), mws)
+}
+
+func mountRoute(prefix string, handler http.Handler) route {
+	return route{
+		path:     prefix,
+		handler:  stripMount(handler),
+		funcName: fmt.Sprintf("%T", handler),
+		prefix:   true,
+	}
+}
+
+// stripMount removes the matched mount path from the request before calling
+// handler. The full path is only known once routers are flattened, so it is
+// read back from the pattern ServeMux matched, one segment per wildcard.
+func stripMount(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		patt := r.Pattern
+		if i := strings.IndexByte(patt, '/'); i >= 0 {
+			patt = patt[i:]
+		}
+		n := strings.Count(strings.TrimSuffix(patt, "/"), "/")
+
+		rawPath := stripSegments(r.URL.EscapedPath(), n)
+		path, err := url.PathUnescape(rawPath)
+		if err != nil {
+			WriteProblem(w, r, NewProblem(http.StatusNotFound, ""))
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = path
+		r2.URL.RawPath = ""
+		if rawPath != path {
+			r2.URL.RawPath = rawPath
+		}
+		handler.ServeHTTP(w, r2)
+	}
+}
+
+// stripSegments drops the first n segments of path, always returning a
+// rooted path.
+func stripSegments(path string, n int) string {
+	for range n {
+		i := strings.IndexByte(path[1:], '/')
+		if i < 0 {
+			return "/"
+		}
+		path = path[i+1:]
+	}
+	return path
+}
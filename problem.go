@@ -0,0 +1,101 @@
+package cafe
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+)
+
+/*** Definitions ***/
+
+// Problem is an RFC 9457 problem details object, rendered as
+// application/problem+json. It is also an error, so handlers can return it
+// as is.
+type Problem struct {
+	// Type is a URI identifying the problem type. Empty means
+	// "about:blank": the problem is described by its status alone.
+	Type string
+	// Title is a short summary of the problem type.
+	Title string
+	// Status is the HTTP status code.
+	Status int
+	// Detail explains this occurrence of the problem.
+	Detail string
+	// Instance is a URI identifying this occurrence of the problem.
+	Instance string
+	// Extensions are additional members, rendered next to the standard
+	// ones, which take precedence on a name clash.
+	Extensions map[string]any
+}
+
+// ProblemContentType is the media type of problem details responses.
+const ProblemContentType = "application/problem+json"
+
+// NewProblem returns the problem for status, titled with its status text and
+// with detail as the explanation of this occurrence.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Title + ": " + p.Detail
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := maps.Clone(p.Extensions)
+	if members == nil {
+		members = map[string]any{}
+	}
+	for name, value := range map[string]string{
+		"type":     p.Type,
+		"title":    p.Title,
+		"detail":   p.Detail,
+		"instance": p.Instance,
+	} {
+		if value != "" {
+			members[name] = value
+		}
+	}
+	if p.Status != 0 {
+		members["status"] = p.Status
+	}
+	return json.Marshal(members)
+}
+
+/*** Rendering ***/
+
+// WriteProblem writes p as an application/problem+json response. The
+// instance defaults to the request path.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		copied := *p
+		copied.Instance = r.URL.Path
+		p = &copied
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		p = NewProblem(http.StatusInternalServerError, "")
+		body, _ = json.Marshal(p)
+	}
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", ProblemContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// problemHandler answers every request with the bare problem for status.
+func problemHandler(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteProblem(w, r, NewProblem(status, ""))
+	}
+}
@@ -2,25 +2,86 @@ package cafe
 
 import (
 	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
 /*** Definitions ***/
 
 type route struct {
-	path    string
-	method  string
-	handler http.HandlerFunc
+	path        string
+	method      string
+	handler     http.HandlerFunc
+	source      string
+	funcName    string
+	name        string
+	input       reflect.Type
+	output      reflect.Type
+	meta        *Route
+	host        string
+	constraints []paramConstraint
+	mounts      []string
+	tags        []string
+	timeout     time.Duration
+	middlewares int
+	prefix      bool
+	spa         bool
+	// notFound is the not-found handler of the innermost router below which
+	// the route is mounted that has one, nil for the app's.
+	notFound http.HandlerFunc
+}
+
+// Route is returned by every route registration, to attach metadata to the
+// route after the fact, e.g. app.Get("/users/{id}", h).Name("user.show").
+type Route struct {
+	name    string
+	tags    []string
+	handler string
+	input   reflect.Type
+	output  reflect.Type
+	update  func(change func())
 }
 
 type Router struct {
+	mu          sync.Mutex
 	routes      []route
 	routers     []mountedRouter
-	middlewares []middleware
+	middlewares []Middleware
+	notFound    http.HandlerFunc
+	nfSource    string
+	options     routeOptions
+	errs        []error
+	watchers    []*func()
 }
 
+// methods lists the standard HTTP methods, in the order Any registers them.
+var methods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// RestParam names the path value holding the rest of the path matched by a
+// Prefix route.
+const RestParam = "rest"
+
 type mountedRouter struct {
-	path   string
-	router *Router
+	path        string
+	router      *Router
+	source      string
+	constraints []paramConstraint
+	// unwatch stops the router from notifying the app or router it is
+	// mounted on, once it is removed.
+	unwatch func()
 }
 
 /*** Init ***/
@@ -35,51 +96,303 @@ func NewRouter() *Router {
 /*** Aggregation ***/
 
 func (r *Router) UseRouter(path string, ro *Router) {
-	for _, mr := range r.routers {
-		if mr.path == path {
-			return
-		}
+	r.mountRouter(mountedRouter{path: path, router: ro, source: callerSite(1)})
+}
+
+func (r *Router) mountRouter(mr mountedRouter) {
+	mr.unwatch = mr.router.watch(r.changed)
+	r.mu.Lock()
+	var err error
+	r.routers, err = addRouter(r.routers, mr)
+	r.errs = appendError(r.errs, err)
+	r.mu.Unlock()
+	if err != nil {
+		mr.unwatch()
 	}
-	r.routers = append(r.routers, mountedRouter{path: path, router: ro})
+	r.changed()
 }
 
-func (r *Router) Use(mw middleware) {
-	r.middlewares = append(r.middlewares, mw)
+func (r *Router) Use(mws ...Middleware) {
+	r.mu.Lock()
+	r.middlewares = append(r.middlewares, mws...)
+	r.mu.Unlock()
+	r.changed()
+}
+
+// NotFound sets a handler for unmatched requests under the router's mount
+// path. It runs behind the router middlewares, like the router routes. A
+// router mounted without a prefix, like an unprefixed group, has no subtree
+// of its own, so Validate rejects its not-found handler.
+func (r *Router) NotFound(handler http.HandlerFunc) {
+	source := callerSite(1)
+	r.mu.Lock()
+	r.notFound, r.nfSource = handler, source
+	r.mu.Unlock()
+	r.changed()
+}
+
+// RemoveRoute removes the router route registered for method and path and
+// reports whether there was one. Apps serving the router pick up the change
+// like App.RemoveRoute.
+func (r *Router) RemoveRoute(method, path string) bool {
+	r.mu.Lock()
+	var ok bool
+	r.routes, ok = removeRoute(r.routes, method, path)
+	r.mu.Unlock()
+	if ok {
+		r.changed()
+	}
+	return ok
+}
+
+// RemoveRouter unmounts the router mounted at path and reports whether there
+// was one.
+func (r *Router) RemoveRouter(path string) bool {
+	r.mu.Lock()
+	var removed mountedRouter
+	var ok bool
+	r.routers, removed, ok = removeRouter(r.routers, path)
+	r.mu.Unlock()
+	if ok {
+		removed.unwatch()
+		r.changed()
+	}
+	return ok
+}
+
+// watch registers fn to be called whenever the router, or a router mounted
+// below it, changes, and returns a function unregistering it.
+func (r *Router) watch(fn func()) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	watcher := &fn
+	r.watchers = append(r.watchers, watcher)
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.watchers = slices.DeleteFunc(r.watchers, func(w *func()) bool { return w == watcher })
+	}
+}
+
+// changed notifies the router watchers, so the apps serving it rebuild their
+// route table. It must be called without r.mu held.
+func (r *Router) changed() {
+	r.mu.Lock()
+	watchers := slices.Clone(r.watchers)
+	r.mu.Unlock()
+	for _, fn := range watchers {
+		(*fn)()
+	}
+}
+
+func (r *Router) addRoute(rt route, mws []Middleware) *Route {
+	if rt.meta == nil {
+		rt.meta = &Route{update: r.updateRoute}
+	}
+	if rt.funcName == "" {
+		rt.funcName = handlerName(rt.handler)
+	}
+	rt.handler = setUpMiddlewares(rt.handler, mws)
+	rt.middlewares = len(mws)
+	rt.source = callerSite(2)
+
+	r.mu.Lock()
+	var err error
+	r.routes, err = addRoute(r.routes, rt)
+	r.errs = appendError(r.errs, err)
+	r.mu.Unlock()
+	r.changed()
+	return rt.meta
+}
+
+// updateRoute changes the metadata of a router route under the router lock,
+// and notifies the app it is mounted on.
+func (r *Router) updateRoute(change func()) {
+	r.mu.Lock()
+	change()
+	r.mu.Unlock()
+	r.changed()
 }
 
 /*** Assembly ***/
 
 func (r *Router) getRoutes() []route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	mountedRoutes := []route{}
 	for _, rt := range r.routes {
+		rt = r.options.apply(rt.withMeta())
+		rt.notFound = r.notFound
 		rt.handler = setUpMiddlewares(rt.handler, r.middlewares)
+		rt.middlewares += len(r.middlewares)
 		mountedRoutes = append(mountedRoutes, rt)
 	}
 	for _, mr := range r.routers {
 		rtrRoutes := mr.router.getRoutes()
 		for _, rt := range rtrRoutes {
 			rt.path = mr.path + rt.path
+			if mr.path != "" {
+				rt.mounts = append([]string{mr.path}, rt.mounts...)
+			}
+			rt.constraints = append(slices.Clone(mr.constraints), rt.constraints...)
+			if rt.notFound == nil {
+				rt.notFound = r.notFound
+			}
+			rt = r.options.apply(rt)
 			rt.handler = setUpMiddlewares(rt.handler, r.middlewares)
+			rt.middlewares += len(r.middlewares)
 			mountedRoutes = append(mountedRoutes, rt)
 		}
 	}
 	return mountedRoutes
 }
 
+// getNotFound returns a not-found handler for the router subtree and for
+// every router below it, so each mount path stays owned by its router even
+// when a broader catch-all route exists. Routers without their own handler
+// inherit parent's.
+func (r *Router) getNotFound(parent http.HandlerFunc) []route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	notFound := r.notFound
+	if notFound == nil {
+		notFound = parent
+	}
+	handlers := []route{{
+		path:    "/",
+		handler: setUpMiddlewares(notFound, r.middlewares),
+	}}
+	for _, mr := range r.routers {
+		for _, nf := range mr.router.getNotFound(notFound) {
+			nf.path = mr.path + nf.path
+			nf.handler = setUpMiddlewares(nf.handler, r.middlewares)
+			handlers = append(handlers, nf)
+		}
+	}
+	return handlers
+}
+
+// getErrors collects the registration errors of the router and of every
+// router mounted below it.
+func (r *Router) getErrors() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	errs := slices.Clone(r.errs)
+	for _, mr := range r.routers {
+		errs = append(errs, mr.router.getErrors()...)
+	}
+	return append(errs, unscopedNotFound(r.routers)...)
+}
+
+// notFoundSource returns where the router's not-found handler was set, or ""
+// when it has none.
+func (r *Router) notFoundSource() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.notFound == nil {
+		return ""
+	}
+	return r.nfSource
+}
+
+// Name names the route, so its full path can be built with App.URL.
+func (rt *Route) Name(name string) *Route {
+	rt.update(func() { rt.name = name })
+	return rt
+}
+
+// Tag adds tags to the route, listed by App.Routes after the tags of the
+// routers it is mounted under.
+func (rt *Route) Tag(tags ...string) *Route {
+	rt.update(func() { rt.tags = append(rt.tags, tags...) })
+	return rt
+}
+
+// Describe sets fn as the handler App.Routes reports for the route. Routes
+// registered with an adapter such as CtxHandler or Handler are otherwise
+// reported as the adapter's closure. For a function adapted with Typed, its
+// In and Out types become the route Input and Output.
+func (rt *Route) Describe(fn any) *Route {
+	name := funcName(fn)
+	input, output := typedSignature(reflect.TypeOf(fn))
+	rt.update(func() { rt.handler, rt.input, rt.output = name, input, output })
+	return rt
+}
+
+// withMeta copies the name, tags and handler of rt's Route into rt, so they can be
+// read without holding the lock of the app or router owning the route. The
+// lock must be held.
+func (rt route) withMeta() route {
+	if rt.meta != nil {
+		rt.name = rt.meta.name
+		rt.tags = append(slices.Clone(rt.tags), rt.meta.tags...)
+		if rt.meta.handler != "" {
+			rt.funcName = rt.meta.handler
+			rt.input, rt.output = rt.meta.input, rt.meta.output
+		}
+	}
+	return rt
+}
+
+func prefixPath(path string) string {
+	return strings.TrimSuffix(path, "/") + "/{" + RestParam + "...}"
+}
+
 /*** Basic HTTP Methods ***/
 
-func (r *Router) Get(path string, handler http.HandlerFunc) {
-	r.routes = addRoute(r.routes, path, "GET", handler)
+func (r *Router) Get(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: path, method: http.MethodGet, handler: handler}, mws)
 }
 
-func (r *Router) Post(path string, handler http.HandlerFunc) {
-	r.routes = addRoute(r.routes, path, "POST", handler)
+func (r *Router) Post(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: path, method: http.MethodPost, handler: handler}, mws)
 }
 
-func (r *Router) Put(path string, handler http.HandlerFunc) {
-	r.routes = addRoute(r.routes, path, "PUT", handler)
+func (r *Router) Put(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: path, method: http.MethodPut, handler: handler}, mws)
 }
 
-func (r *Router) Delete(path string, handler http.HandlerFunc) {
-	r.routes = addRoute(r.routes, path, "DELETE", handler)
+func (r *Router) Delete(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: path, method: http.MethodDelete, handler: handler}, mws)
+}
+
+func (r *Router) Patch(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: path, method: http.MethodPatch, handler: handler}, mws)
+}
+
+func (r *Router) Head(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: path, method: http.MethodHead, handler: handler}, mws)
+}
+
+func (r *Router) Options(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: path, method: http.MethodOptions, handler: handler}, mws)
+}
+
+func (r *Router) Connect(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: path, method: http.MethodConnect, handler: handler}, mws)
+}
+
+func (r *Router) Trace(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: path, method: http.MethodTrace, handler: handler}, mws)
+}
+
+/*** Generic HTTP Methods ***/
+
+func (r *Router) Handle(method, path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: path, method: method, handler: handler}, mws)
+}
+
+// Prefix registers handler for path and everything below it. The rest of
+// the path is available as r.PathValue(cafe.RestParam).
+func (r *Router) Prefix(method, path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	return r.addRoute(route{path: prefixPath(path), method: method, handler: handler}, mws)
+}
+
+// Any registers handler for every standard HTTP method on path.
+func (r *Router) Any(path string, handler http.HandlerFunc, mws ...Middleware) *Route {
+	meta := &Route{update: r.updateRoute}
+	for _, method := range methods {
+		r.addRoute(route{path: path, method: method, handler: handler, meta: meta}, mws)
+	}
+	return meta
 }
@@ -0,0 +1,105 @@
+package cafe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"text/tabwriter"
+	"time"
+)
+
+/*** Definitions ***/
+
+// RouteInfo describes a route of the final, flattened route table. Method
+// is empty for handlers mounted with Mount, which serve every method.
+type RouteInfo struct {
+	Name        string        `json:"name,omitempty"`
+	Method      string        `json:"method"`
+	Host        string        `json:"host,omitempty"`
+	Path        string        `json:"path"`
+	Mounts      []string      `json:"mounts"`
+	Tags        []string      `json:"tags,omitempty"`
+	Timeout     time.Duration `json:"timeout,omitempty"`
+	Middlewares int           `json:"middlewares"`
+	Handler     string        `json:"handler"`
+	Source      string        `json:"source"`
+	// Input and Output are the request and response types of routes
+	// registered with HandleTyped, e.g. to generate API documentation.
+	Input  reflect.Type `json:"-"`
+	Output reflect.Type `json:"-"`
+}
+
+/*** Introspection ***/
+
+// Routes lists every route the app serves, in registration order, with the
+// full path built from its router mount chain.
+func (a *App) Routes() []RouteInfo {
+	a.mu.Lock()
+	routes := a.getRoutes()
+	a.mu.Unlock()
+
+	infos := []RouteInfo{}
+	for _, rt := range routes {
+		mounts := rt.mounts
+		if mounts == nil {
+			mounts = []string{}
+		}
+		infos = append(infos, RouteInfo{
+			Name:        rt.name,
+			Method:      rt.method,
+			Host:        rt.host,
+			Path:        rt.path,
+			Mounts:      mounts,
+			Tags:        rt.tags,
+			Timeout:     rt.timeout,
+			Middlewares: rt.middlewares,
+			Handler:     rt.funcName,
+			Source:      rt.source,
+			Input:       rt.input,
+			Output:      rt.output,
+		})
+	}
+	return infos
+}
+
+// PrintRoutes writes the route table to w as aligned text columns.
+func (a *App) PrintRoutes(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATH\tMIDDLEWARES\tHANDLER")
+	for _, ri := range a.Routes() {
+		method := ri.Method
+		if method == "" {
+			method = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", method, ri.Host+ri.Path, ri.Middlewares, ri.Handler)
+	}
+	return tw.Flush()
+}
+
+// RoutesHandler serves the route table as JSON, e.g. on a debug endpoint.
+func (a *App) RoutesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.Routes())
+	}
+}
+
+/*** Helpers ***/
+
+func handlerName(handler http.HandlerFunc) string {
+	if handler == nil {
+		return ""
+	}
+	return funcName(handler)
+}
+
+func funcName(fn any) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	return f.Name()
+}
@@ -0,0 +1,371 @@
+package cafe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*** Definitions ***/
+
+// StaticOptions configures a Static mount. The zero value serves index.html
+// for directories, hides directory listings and only relies on ETag and
+// Last-Modified validation, except for hashed file names, which are always
+// served as immutable.
+type StaticOptions struct {
+	// Index is the file served for a directory. Defaults to "index.html".
+	Index string
+	// Browse enables directory listings when a directory has no index file.
+	Browse bool
+	// Precompressed serves a ".br" or ".gz" sibling of the requested file
+	// when it exists and the client accepts that encoding.
+	Precompressed bool
+	// MaxAge sets a public Cache-Control max-age for non-immutable files.
+	MaxAge time.Duration
+	// Immutable reports whether a file name is content-addressed, so it can
+	// be cached forever. Defaults to IsHashedName.
+	Immutable func(name string) bool
+}
+
+// SPAOptions configures an SPA mount. Files that exist are served as with
+// Static; any other extension-less path gets the fallback file.
+type SPAOptions struct {
+	StaticOptions
+	// Fallback is the file served for unknown paths. Defaults to
+	// "index.html".
+	Fallback string
+}
+
+type staticHandler struct {
+	fsys     fs.FS
+	opts     StaticOptions
+	fallback string
+	etags    sync.Map // name -> contentETag
+}
+
+type contentETag struct {
+	size int64
+	etag string
+}
+
+// encodings lists the precompressed siblings looked up, in preference order.
+var encodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+var hashedName = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[0-9a-z]+$`)
+
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+/*** Registration ***/
+
+// Static serves the files of fsys under prefix, for GET and HEAD. Use
+// os.DirFS for files on disk or an embed.FS for embedded assets.
+func (a *App) Static(prefix string, fsys fs.FS, opts StaticOptions, mws ...Middleware) *Route {
+	return a.addRoute(staticRoute(prefix, fsys, opts), mws)
+}
+
+// Static serves the files of fsys under prefix, for GET and HEAD, behind
+// the router middlewares.
+func (r *Router) Static(prefix string, fsys fs.FS, opts StaticOptions, mws ...Middleware) *Route {
+	return r.addRoute(staticRoute(prefix, fsys, opts), mws)
+}
+
+// SPA serves a single-page application from fsys under prefix: existing
+// files are served as with Static, and unknown GET paths without a file
+// extension get the fallback file. Paths owned by mounted routers keep
+// their own 404s.
+func (a *App) SPA(prefix string, fsys fs.FS, opts SPAOptions, mws ...Middleware) *Route {
+	return a.addRoute(spaRoute(prefix, fsys, opts), mws)
+}
+
+// SPA serves a single-page application from fsys under prefix, behind the
+// router middlewares.
+func (r *Router) SPA(prefix string, fsys fs.FS, opts SPAOptions, mws ...Middleware) *Route {
+	return r.addRoute(spaRoute(prefix, fsys, opts), mws)
+}
+
+func staticRoute(prefix string, fsys fs.FS, opts StaticOptions) route {
+	return newStaticHandler(fsys, opts, "").route(prefix)
+}
+
+func spaRoute(prefix string, fsys fs.FS, opts SPAOptions) route {
+	if opts.Fallback == "" {
+		opts.Fallback = "index.html"
+	}
+	rt := newStaticHandler(fsys, opts.StaticOptions, opts.Fallback).route(prefix)
+	rt.spa = true
+	return rt
+}
+
+func newStaticHandler(fsys fs.FS, opts StaticOptions, fallback string) *staticHandler {
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+	if opts.Immutable == nil {
+		opts.Immutable = IsHashedName
+	}
+	return &staticHandler{fsys: fsys, opts: opts, fallback: fallback}
+}
+
+func (sh *staticHandler) route(prefix string) route {
+	return route{
+		path:     prefixPath(prefix),
+		method:   http.MethodGet,
+		handler:  sh.serve,
+		funcName: fmt.Sprintf("static %T", sh.fsys),
+	}
+}
+
+// IsHashedName reports whether name carries a content hash of at least
+// eight hex digits before its extension, e.g. app.3f9a2c1d.js.
+func IsHashedName(name string) bool {
+	return hashedName.MatchString(path.Base(name))
+}
+
+// spaEntries gives every method-less entry below an SPA route one pattern
+// per method instead. The SPA catch-all is a GET pattern, so a method-less
+// pattern below it conflicts with it rather than winning, and the
+// OPTIONS/405 answers, mounts and not-found handlers there would be lost to
+// the SPA fallback. HEAD is left to the GET patterns, which serve it too.
+func spaEntries(routes []route, entries []muxEntry) []muxEntry {
+	prefixes := []string{}
+	for _, rt := range routes {
+		if rt.spa {
+			prefixes = append(prefixes, shapeOf(strings.TrimSuffix(rt.path, "{"+RestParam+"...}")))
+		}
+	}
+	if len(prefixes) == 0 {
+		return entries
+	}
+
+	spread := []muxEntry{}
+	for _, e := range entries {
+		if strings.Contains(e.pattern, " ") || !belowAny(shapeOf(e.pattern), prefixes) {
+			spread = append(spread, e)
+			continue
+		}
+		for _, method := range methods {
+			if method == http.MethodHead {
+				continue
+			}
+			me := e
+			me.pattern = method + " " + e.pattern
+			spread = append(spread, me)
+		}
+	}
+	return spread
+}
+
+// belowAny reports whether path lies strictly below one of prefixes.
+func belowAny(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if len(path) > len(prefix) && strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/*** Serving ***/
+
+func (sh *staticHandler) serve(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.PathValue(RestParam)), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		WriteProblem(w, r, NewProblem(http.StatusNotFound, ""))
+		return
+	}
+
+	info, err := fs.Stat(sh.fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		sh.notFound(w, r, name)
+		return
+	}
+	if err != nil {
+		staticError(w, r, err)
+		return
+	}
+	if !info.IsDir() {
+		sh.serveFile(w, r, name)
+		return
+	}
+
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		u := url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+		return
+	}
+	index := path.Join(name, sh.opts.Index)
+	if info, err := fs.Stat(sh.fsys, index); err == nil && !info.IsDir() {
+		sh.serveFile(w, r, index)
+		return
+	}
+	if sh.opts.Browse {
+		sh.serveDir(w, r, name)
+		return
+	}
+	sh.notFound(w, r, name)
+}
+
+// notFound serves the SPA fallback for extension-less paths, and a 404
+// otherwise.
+func (sh *staticHandler) notFound(w http.ResponseWriter, r *http.Request, name string) {
+	if sh.fallback == "" || path.Ext(name) != "" {
+		WriteProblem(w, r, NewProblem(http.StatusNotFound, ""))
+		return
+	}
+	sh.serveFile(w, r, sh.fallback)
+}
+
+func (sh *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	servedName := name
+	if sh.opts.Precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+		for _, enc := range encodings {
+			if !acceptsEncoding(r, enc.name) {
+				continue
+			}
+			if info, err := fs.Stat(sh.fsys, name+enc.ext); err == nil && !info.IsDir() {
+				servedName = name + enc.ext
+				w.Header().Set("Content-Encoding", enc.name)
+				break
+			}
+		}
+	}
+
+	f, err := sh.fsys.Open(servedName)
+	if err != nil {
+		staticError(w, r, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		staticError(w, r, err)
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			staticError(w, r, err)
+			return
+		}
+		content = bytes.NewReader(b)
+	}
+
+	etag, err := sh.etag(servedName, info, content)
+	if err != nil {
+		staticError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	if name == sh.fallback {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else if sh.opts.Immutable(name) {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else if sh.opts.MaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(sh.opts.MaxAge.Seconds())))
+	}
+
+	// The original name keeps Content-Type detection on the real extension.
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// etag derives a validator from size and modification time when the file
+// system has them, and from a cached content hash otherwise (embed.FS
+// reports no modification time).
+func (sh *staticHandler) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+	}
+	if cached, ok := sh.etags.Load(name); ok {
+		if ce := cached.(contentETag); ce.size == info.Size() {
+			return ce.etag, nil
+		}
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	sh.etags.Store(name, contentETag{size: info.Size(), etag: etag})
+	return etag, nil
+}
+
+func (sh *staticHandler) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	entries, err := fs.ReadDir(sh.fsys, name)
+	if err != nil {
+		staticError(w, r, err)
+		return
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<!doctype html>")
+	fmt.Fprintln(w, `<meta name="viewport" content="width=device-width">`)
+	fmt.Fprintln(w, "<pre>")
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() {
+			n += "/"
+		}
+		u := url.URL{Path: n}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(n))
+	}
+	fmt.Fprintln(w, "</pre>")
+}
+
+/*** Helpers ***/
+
+// acceptsEncoding reports whether the Accept-Encoding header allows enc,
+// honouring an explicit q=0.
+func acceptsEncoding(r *http.Request, enc string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), enc) {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+func staticError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		WriteProblem(w, r, NewProblem(http.StatusNotFound, ""))
+	case errors.Is(err, fs.ErrPermission):
+		WriteProblem(w, r, NewProblem(http.StatusForbidden, ""))
+	default:
+		WriteProblem(w, r, NewProblem(http.StatusInternalServerError, ""))
+	}
+}
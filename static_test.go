@@ -0,0 +1,308 @@
+package cafe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testFS() fstest.MapFS {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return fstest.MapFS{
+		"index.html":           {Data: []byte("<h1>home</h1>"), ModTime: modTime},
+		"app.3f9a2c1d.js":      {Data: []byte("console.log(1)"), ModTime: modTime},
+		"style.css":            {Data: []byte("body{}"), ModTime: modTime},
+		"style.css.gz":         {Data: []byte("gzipped"), ModTime: modTime},
+		"style.css.br":         {Data: []byte("brotli"), ModTime: modTime},
+		"docs/guide.txt":       {Data: []byte("guide"), ModTime: modTime},
+		"docs/faq.txt":         {Data: []byte("faq"), ModTime: modTime},
+		"embedded/no-time.txt": {Data: []byte("no mod time")},
+	}
+}
+
+func serveStatic(app *App, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestStatic_ServesFilesAndIndex(t *testing.T) {
+	app := NewServer()
+	app.Static("/assets", testFS(), StaticOptions{})
+
+	rr := serveStatic(&app, "GET", "/assets/style.css", nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", rr.Code)
+	}
+	if rr.Body.String() != "body{}" {
+		t.Errorf("Expected body 'body{}', got '%s'", rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+		t.Errorf("Expected text/css content type, got '%s'", ct)
+	}
+	if rr.Header().Get("Last-Modified") == "" || rr.Header().Get("ETag") == "" {
+		t.Errorf("Expected Last-Modified and ETag headers, got %v", rr.Header())
+	}
+
+	rr = serveStatic(&app, "GET", "/assets/", nil)
+	if rr.Body.String() != "<h1>home</h1>" {
+		t.Errorf("Expected index.html, got '%s'", rr.Body.String())
+	}
+
+	rr = serveStatic(&app, "GET", "/assets/docs", nil)
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/assets/docs/" {
+		t.Errorf("Expected redirect to /assets/docs/, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	rr = serveStatic(&app, "GET", "/assets/docs/", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found without directory listing, got %d", rr.Code)
+	}
+
+	rr = serveStatic(&app, "GET", "/assets/missing.js", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found, got %d", rr.Code)
+	}
+}
+
+func TestStatic_Browse(t *testing.T) {
+	app := NewServer()
+	app.Static("/assets", testFS(), StaticOptions{Browse: true})
+
+	rr := serveStatic(&app, "GET", "/assets/docs/", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `<a href="faq.txt">faq.txt</a>`) || !strings.Contains(body, `<a href="guide.txt">guide.txt</a>`) {
+		t.Errorf("Expected directory listing, got '%s'", body)
+	}
+}
+
+func TestStatic_ConditionalRequests(t *testing.T) {
+	app := NewServer()
+	app.Static("/assets", testFS(), StaticOptions{})
+
+	for _, path := range []string{"/assets/style.css", "/assets/embedded/no-time.txt"} {
+		rr := serveStatic(&app, "GET", path, nil)
+		etag := rr.Header().Get("ETag")
+		if etag == "" {
+			t.Fatalf("For %s, expected an ETag", path)
+		}
+
+		rr = serveStatic(&app, "GET", path, http.Header{"If-None-Match": {etag}})
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("For %s, expected status Not Modified, got %d", path, rr.Code)
+		}
+	}
+
+	rr := serveStatic(&app, "GET", "/assets/style.css", http.Header{
+		"If-Modified-Since": {time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)},
+	})
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status Not Modified, got %d", rr.Code)
+	}
+}
+
+func TestStatic_Precompressed(t *testing.T) {
+	app := NewServer()
+	app.Static("/assets", testFS(), StaticOptions{Precompressed: true})
+
+	tests := []struct {
+		acceptEncoding   string
+		expectedEncoding string
+		expectedBody     string
+	}{
+		{"gzip, br", "br", "brotli"},
+		{"gzip", "gzip", "gzipped"},
+		{"br;q=0, gzip", "gzip", "gzipped"},
+		{"", "", "body{}"},
+	}
+	for _, tt := range tests {
+		rr := serveStatic(&app, "GET", "/assets/style.css", http.Header{"Accept-Encoding": {tt.acceptEncoding}})
+
+		if enc := rr.Header().Get("Content-Encoding"); enc != tt.expectedEncoding {
+			t.Errorf("For '%s', expected encoding '%s', got '%s'", tt.acceptEncoding, tt.expectedEncoding, enc)
+		}
+		if rr.Body.String() != tt.expectedBody {
+			t.Errorf("For '%s', expected body '%s', got '%s'", tt.acceptEncoding, tt.expectedBody, rr.Body.String())
+		}
+		if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+			t.Errorf("For '%s', expected text/css content type, got '%s'", tt.acceptEncoding, ct)
+		}
+		if rr.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("For '%s', expected Vary: Accept-Encoding", tt.acceptEncoding)
+		}
+	}
+}
+
+func TestStatic_CacheControl(t *testing.T) {
+	app := NewServer()
+	app.Static("/assets", testFS(), StaticOptions{MaxAge: time.Hour})
+
+	rr := serveStatic(&app, "GET", "/assets/app.3f9a2c1d.js", nil)
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=31536000, immutable" {
+		t.Errorf("Expected immutable Cache-Control, got '%s'", cc)
+	}
+
+	rr = serveStatic(&app, "GET", "/assets/style.css", nil)
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Errorf("Expected max-age Cache-Control, got '%s'", cc)
+	}
+}
+
+func TestRouter_Static_RunsThroughMiddleware(t *testing.T) {
+	app := NewServer()
+	var callOrder []string
+	app.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			callOrder = append(callOrder, "appMw")
+			next(w, r)
+		}
+	})
+
+	web := NewRouter()
+	web.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			callOrder = append(callOrder, "routerMw")
+			next(w, r)
+		}
+	})
+	web.Static("/static", testFS(), StaticOptions{})
+	app.UseRouter("/web", web)
+
+	rr := serveStatic(&app, "HEAD", "/web/static/style.css", nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", rr.Code)
+	}
+	if len(callOrder) != 2 || callOrder[0] != "appMw" || callOrder[1] != "routerMw" {
+		t.Errorf("Expected [appMw routerMw], got %v", callOrder)
+	}
+}
+
+func TestIsHashedName(t *testing.T) {
+	tests := map[string]bool{
+		"app.3f9a2c1d.js":            true,
+		"assets/main-0123456789.css": true,
+		"app.js":                     false,
+		"my-document.txt":            false,
+		"logo.png":                   false,
+	}
+	for name, expected := range tests {
+		if got := IsHashedName(name); got != expected {
+			t.Errorf("IsHashedName(%q) = %v, expected %v", name, got, expected)
+		}
+	}
+}
+
+func TestSPA_Fallback(t *testing.T) {
+	app := NewServer()
+	api := NewRouter()
+	api.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("users"))
+	})
+	app.UseRouter("/api", api)
+	app.SPA("/", testFS(), SPAOptions{})
+
+	tests := []struct {
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"/", http.StatusOK, "<h1>home</h1>"},
+		{"/dashboard/settings", http.StatusOK, "<h1>home</h1>"},
+		{"/style.css", http.StatusOK, "body{}"},
+		{"/missing.js", http.StatusNotFound, `{"instance":"/missing.js","status":404,"title":"Not Found"}` + "\n"},
+		{"/api/users/", http.StatusOK, "users"},
+		{"/api/unknown", http.StatusNotFound, `{"instance":"/api/unknown","status":404,"title":"Not Found"}` + "\n"},
+	}
+	for _, tt := range tests {
+		rr := serveStatic(&app, "GET", tt.path, nil)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("For %s, expected status %d, got %d", tt.path, tt.expectedCode, rr.Code)
+		}
+		if rr.Body.String() != tt.expectedBody {
+			t.Errorf("For %s, expected body '%s', got '%s'", tt.path, tt.expectedBody, rr.Body.String())
+		}
+	}
+
+	rr := serveStatic(&app, "GET", "/dashboard", nil)
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Expected no-cache for fallback file, got '%s'", cc)
+	}
+}
+
+func TestSPA_AllowedMethodsAndMounts(t *testing.T) {
+	app := NewServer()
+	api := NewRouter()
+	api.Post("/items", func(w http.ResponseWriter, r *http.Request) {})
+	app.UseRouter("/api", api)
+	app.Post("/upload", func(w http.ResponseWriter, r *http.Request) {})
+	app.Mount("/legacy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("legacy " + r.Method + " " + r.URL.Path))
+	}))
+	app.SPA("/", testFS(), SPAOptions{})
+	if err := app.Validate(); err != nil {
+		t.Fatalf("Expected a mount next to an SPA to validate, got %v", err)
+	}
+
+	tests := []struct {
+		method, path string
+		code         int
+		allow, body  string
+	}{
+		{"GET", "/api/items", http.StatusMethodNotAllowed, "OPTIONS, POST", ""},
+		{"DELETE", "/api/items", http.StatusMethodNotAllowed, "OPTIONS, POST", ""},
+		{"OPTIONS", "/api/items", http.StatusNoContent, "OPTIONS, POST", ""},
+		{"GET", "/upload", http.StatusMethodNotAllowed, "OPTIONS, POST", ""},
+		{"HEAD", "/upload", http.StatusMethodNotAllowed, "OPTIONS, POST", ""},
+		{"GET", "/api/unknown", http.StatusNotFound, "", ""},
+		{"GET", "/legacy/x", http.StatusOK, "", "legacy GET /x"},
+		{"PUT", "/legacy/x", http.StatusOK, "", "legacy PUT /x"},
+		{"GET", "/dashboard", http.StatusOK, "", "<h1>home</h1>"},
+		{"OPTIONS", "/dashboard", http.StatusNoContent, "GET, HEAD, OPTIONS", ""},
+	}
+	for _, tt := range tests {
+		rr := serveStatic(&app, tt.method, tt.path, nil)
+		if rr.Code != tt.code {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.code, rr.Code)
+		}
+		if allow := rr.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: expected Allow '%s', got '%s'", tt.method, tt.path, tt.allow, allow)
+		}
+		if tt.body != "" && rr.Body.String() != tt.body {
+			t.Errorf("%s %s: expected body '%s', got '%s'", tt.method, tt.path, tt.body, rr.Body.String())
+		}
+	}
+}
+
+func TestRouter_SPA_CustomFallback(t *testing.T) {
+	app := NewServer()
+	web := NewRouter()
+	admin := NewRouter()
+	admin.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("admin 404"))
+	})
+	web.UseRouter("/admin", admin)
+	web.SPA("/app", testFS(), SPAOptions{Fallback: "docs/guide.txt"})
+	app.UseRouter("/web", web)
+
+	rr := serveStatic(&app, "GET", "/web/app/some/route", nil)
+	if rr.Body.String() != "guide" {
+		t.Errorf("Expected fallback body 'guide', got '%s'", rr.Body.String())
+	}
+
+	rr = serveStatic(&app, "GET", "/web/admin/x", nil)
+	if rr.Code != http.StatusNotFound || rr.Body.String() != "admin 404" {
+		t.Errorf("Expected admin 404, got %d '%s'", rr.Code, rr.Body.String())
+	}
+}
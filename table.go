@@ -0,0 +1,136 @@
+package cafe
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+/*** Definitions ***/
+
+// routeTable is everything a request is dispatched with, built from the
+// registered routes. It is never modified: changing the routes of a running
+// App builds a new table and swaps it in, while requests in flight finish
+// on the one they started with.
+type routeTable struct {
+	settings settings
+	mux      Matcher
+	hosts    []hostRouter
+	names    map[string]string
+	notFound Matcher
+}
+
+// routeSwitch holds the current routeTable, nil until the first request.
+type routeSwitch struct {
+	atomic.Pointer[routeTable]
+}
+
+/*** Setup ***/
+
+// setUpRouters returns the current route table, building it the first time.
+func (a *App) setUpRouters() *routeTable {
+	if t := a.handler.Load(); t != nil {
+		return t
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.handler.Load() == nil {
+		a.handler.Store(a.buildTable())
+	}
+	return a.handler.Load()
+}
+
+// reload swaps in a new route table once the app is serving. Before that,
+// the table is built on the first request. a.mu must be held.
+func (a *App) reload() {
+	if a.handler.Load() != nil {
+		a.handler.Store(a.buildTable())
+	}
+}
+
+// routerChanged is called when a router mounted on the app, directly or
+// through other routers, registers or removes a route.
+func (a *App) routerChanged() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reload()
+}
+
+func (a *App) buildTable() *routeTable {
+	routes := a.getRoutes()
+	t := &routeTable{
+		settings: a.settings,
+		mux:      a.settings.newMatcher(),
+		notFound: a.settings.newMatcher(),
+	}
+	a.register(t.mux, a.muxEntries(""))
+	a.register(t.notFound, a.notFoundEntries(""))
+	for _, h := range a.hosts {
+		h.mux = a.settings.newMatcher()
+		h.notFound = a.settings.newMatcher()
+		a.register(h.mux, a.muxEntries(h.host))
+		a.register(h.notFound, a.notFoundEntries(h.host))
+		t.hosts = append(t.hosts, h)
+	}
+	t.names = routeNames(routes)
+	return t
+}
+
+/*** Removal ***/
+
+// RemoveRoute removes the app route registered for method and path, as they
+// were passed when registering it, and reports whether there was one. Mount
+// routes have no method. On a running app, the route table is rebuilt and
+// swapped in without interrupting requests in flight.
+func (a *App) RemoveRoute(method, path string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var ok bool
+	a.routes, ok = removeRoute(a.routes, method, path)
+	if ok {
+		a.reload()
+	}
+	return ok
+}
+
+// RemoveRouter unmounts the router mounted at path and reports whether there
+// was one.
+func (a *App) RemoveRouter(path string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var removed mountedRouter
+	var ok bool
+	a.routers, removed, ok = removeRouter(a.routers, path)
+	if ok {
+		removed.unwatch()
+		a.reload()
+	}
+	return ok
+}
+
+func removeRoute(routes []route, method, path string) ([]route, bool) {
+	clean, _, err := parseConstraints(path)
+	if err != nil {
+		return routes, false
+	}
+	for i, rt := range routes {
+		if rt.method == method && (rt.path == clean || rt.path == prefixPath(clean)) {
+			return slices.Delete(routes, i, i+1), true
+		}
+	}
+	return routes, false
+}
+
+// removeRouter removes the router mounted at path, returning it so it can
+// be unwatched.
+func removeRouter(routers []mountedRouter, path string) ([]mountedRouter, mountedRouter, bool) {
+	clean, _, err := parseConstraints(path)
+	if err != nil {
+		return routers, mountedRouter{}, false
+	}
+	for i, mr := range routers {
+		if mr.path == clean {
+			return slices.Delete(routers, i, i+1), mr, true
+		}
+	}
+	return routers, mountedRouter{}, false
+}
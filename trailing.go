@@ -0,0 +1,274 @@
+package cafe
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+)
+
+/*** Definitions ***/
+
+// foldedPath is the request path a case-insensitive app matched lower case.
+type foldedPath struct {
+	original string
+	folded   string
+}
+
+type foldedPathKey struct{}
+
+// TrailingSlash decides how requests for a path that is not written the way
+// its route is registered are answered: with a trailing slash added or
+// removed, with repeated slashes or "." and ".." segments, or, with
+// WithCaseInsensitivePaths, with upper case letters.
+type TrailingSlash int
+
+const (
+	// TrailingSlashLenient serves the route for both forms, cleaning the
+	// path internally. "/users" and "/users/" reach the same handler.
+	TrailingSlashLenient TrailingSlash = iota
+	// TrailingSlashStrict matches the path exactly as registered. Any other
+	// form is answered by the not-found handler.
+	TrailingSlashStrict
+	// TrailingSlashRedirect redirects any other form to the registered one,
+	// with a 301 for GET and HEAD and a 308 for every other method, so the
+	// method and body are kept.
+	TrailingSlashRedirect
+)
+
+/*** Options ***/
+
+// WithTrailingSlash sets the policy for non-canonical paths. The default is
+// TrailingSlashLenient.
+func WithTrailingSlash(policy TrailingSlash) Option {
+	return func(s *settings) {
+		s.trailingSlash = policy
+	}
+}
+
+// WithCaseInsensitivePaths makes lower case the canonical form of the literal
+// segments of every path. Routes are registered lower case and requests with
+// upper case literals are handled by the trailing-slash policy like any other
+// non-canonical path. Wildcard names, and the path values they match, keep
+// their case.
+func WithCaseInsensitivePaths() Option {
+	return func(s *settings) {
+		s.caseInsensitive = true
+	}
+}
+
+/*** Setup ***/
+
+// muxPath turns a route path into the ServeMux path it is served under.
+// Leniently, every path is served in its trailing-slash form; otherwise
+// paths are matched exactly as written.
+func (s settings) muxPath(path string) string {
+	if s.trailingSlash != TrailingSlashLenient && !strings.HasSuffix(path, "/") {
+		return path
+	}
+	return muxPath(path)
+}
+
+// toggledEntries registers the other trailing-slash form of every route
+// and OPTIONS/405 entry, so it doesn't reach ServeMux's own redirects.
+// Leniently, it is served by the same handler, which sees the request path
+// unchanged; otherwise by the policy handler.
+func (a *App) toggledEntries(entries []muxEntry) []muxEntry {
+	toggled := []muxEntry{}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		method, path, ok := strings.Cut(e.pattern, " ")
+		if !ok {
+			method, path = "", e.pattern
+		}
+		path = toggledPath(path)
+		if path == "" {
+			continue
+		}
+		if a.settings.trailingSlash == TrailingSlashLenient {
+			pattern := strings.TrimSpace(method + " " + path)
+			toggled = append(toggled, muxEntry{pattern: pattern, handler: e.handler, derived: true})
+			continue
+		}
+		if !seen[shapeOf(path)] {
+			seen[shapeOf(path)] = true
+			toggled = append(toggled, muxEntry{pattern: path, handler: a.toggled(toggledPath(path)), derived: true})
+		}
+	}
+	return toggled
+}
+
+// toggledPath is the other trailing-slash form of a pattern path, or "" for
+// the root.
+func toggledPath(pattern string) string {
+	switch {
+	case strings.HasSuffix(pattern, "...}"):
+		pattern = pattern[:strings.LastIndex(pattern, "/")]
+	case strings.HasSuffix(pattern, "/{$}"):
+		pattern = strings.TrimSuffix(pattern, "/{$}")
+	case strings.HasSuffix(pattern, "/"):
+		pattern = strings.TrimSuffix(pattern, "/")
+	default:
+		return pattern + "/{$}"
+	}
+	return pattern
+}
+
+// lowerLiterals lower-cases path, except for wildcard names and constraints.
+func lowerLiterals(path string) string {
+	var b strings.Builder
+	depth := 0
+	for _, c := range path {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+		if depth == 0 {
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+/*** Dispatch ***/
+
+// cleanPath returns the canonical form of a request path: rooted, without
+// repeated slashes or "." and ".." segments, keeping its trailing slash.
+func (s settings) cleanPath(p string) string {
+	if p == "" || p[0] != '/' {
+		p = "/" + p
+	}
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean
+}
+
+// toggled handles requests for the other trailing-slash form of the route
+// registered under the pattern path.
+func (a *App) toggled(pattern string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if fp, ok := r.Context().Value(foldedPathKey{}).(foldedPath); ok && fp.folded == p {
+			p = foldLiterals(pattern, fp.original)
+		}
+		if strings.HasSuffix(p, "/") {
+			p = strings.TrimSuffix(p, "/")
+		} else {
+			p += "/"
+		}
+		a.handler.Load().nonCanonical(p)(w, r)
+	}
+}
+
+// unfoldCase serves a route of a case-insensitive app, matched on the lower
+// case path. Its canonical path has the literal segments of pattern in lower
+// case and the rest as requested, so path values keep their case. A request
+// for another form is handled by the trailing-slash policy.
+func (a *App) unfoldCase(handler http.HandlerFunc, pattern string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fp, ok := r.Context().Value(foldedPathKey{}).(foldedPath)
+		if !ok || fp.folded != r.URL.Path {
+			handler(w, r)
+			return
+		}
+		canonical := foldLiterals(pattern, fp.original)
+		if canonical != fp.original && a.settings.trailingSlash != TrailingSlashLenient {
+			a.handler.Load().nonCanonical(canonical)(w, r)
+			return
+		}
+		r = withPath(r, canonical)
+		segments := strings.Split(canonical, "/")
+		for i, s := range strings.Split(pattern, "/") {
+			if i >= len(segments) || !strings.HasPrefix(s, "{") || s == "{$}" {
+				continue
+			}
+			name := strings.Trim(s, "{}")
+			if rest, ok := strings.CutSuffix(name, "..."); ok {
+				r.SetPathValue(rest, strings.Join(segments[i:], "/"))
+				break
+			}
+			r.SetPathValue(name, segments[i])
+		}
+		handler(w, r)
+	}
+}
+
+// foldLiterals lower-cases the segments of p that are literal in the route
+// pattern path, keeping wildcard segments and everything below a catch-all
+// or prefix route as they are.
+func foldLiterals(pattern, p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range strings.Split(pattern, "/") {
+		if i >= len(segments) || strings.HasSuffix(s, "...}") {
+			break
+		}
+		if !strings.HasPrefix(s, "{") {
+			segments[i] = strings.ToLower(segments[i])
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// nonCanonical answers a request whose canonical path is canonical,
+// according to the trailing-slash policy. Strictly, it goes to the not-found
+// handler owning the canonical path, and leniently, it is served as if it
+// had been made for the canonical path.
+func (t *routeTable) nonCanonical(canonical string) http.HandlerFunc {
+	switch t.settings.trailingSlash {
+	case TrailingSlashStrict:
+		return func(w http.ResponseWriter, r *http.Request) {
+			p := canonical
+			if t.settings.caseInsensitive {
+				p = strings.ToLower(p)
+			}
+			r = withPath(r, p)
+			_, notFound := t.hostMatchers(r)
+			notFound.ServeHTTP(w, r)
+		}
+	case TrailingSlashRedirect:
+		return func(w http.ResponseWriter, r *http.Request) {
+			code := http.StatusPermanentRedirect
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				code = http.StatusMovedPermanently
+			}
+			target := &url.URL{Path: canonical, RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, target.String(), code)
+		}
+	default:
+		return func(w http.ResponseWriter, r *http.Request) {
+			t.dispatch(w, withPath(r, canonical))
+		}
+	}
+}
+
+// dispatch serves r with the Matcher of its host. With case-insensitive
+// paths, the Matcher sees the path lower case and the matched route restores
+// it; see unfoldCase.
+func (t *routeTable) dispatch(w http.ResponseWriter, r *http.Request) {
+	if t.settings.caseInsensitive {
+		if folded := strings.ToLower(r.URL.Path); folded != r.URL.Path {
+			fp := foldedPath{original: r.URL.Path, folded: folded}
+			r = withPath(r, folded)
+			r = r.WithContext(context.WithValue(r.Context(), foldedPathKey{}, fp))
+		}
+	}
+	t.hostHandler(r).ServeHTTP(w, r)
+}
+
+// withPath returns a shallow copy of r for path p.
+func withPath(r *http.Request, p string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	r2.URL.RawPath = ""
+	return r2
+}
@@ -0,0 +1,202 @@
+package cafe
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*** Adapters ***/
+
+// Typed adapts fn into a handler usable with every route helper and
+// middleware. The request is bound into In: a struct is filled and
+// validated with Bind, from its path, query, header and body fields, and any
+// other type is decoded from the body. fn's result is encoded in the format
+// negotiated with the Accept header: JSON by default, XML for structs, and
+// plain text for strings and encoding.TextMarshaler values. An empty struct
+// Out answers 204 No Content.
+//
+// Binding, negotiation and fn errors go to the error handler in scope for
+// the route. Register fn with HandleTyped to have Routes list In and Out as
+// the route Input and Output.
+func Typed[In, Out any](fn func(ctx context.Context, in In) (Out, error)) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var in In
+		if err := bindTyped(r, &in); err != nil {
+			handleError(w, r, err)
+			return
+		}
+		out, err := fn(r.Context(), in)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		if err := writeNegotiated(w, r, out); err != nil {
+			handleError(w, r, err)
+		}
+	}
+	return handler
+}
+
+// Registrar is implemented by *App and *Router, the targets of
+// HandleTyped.
+type Registrar interface {
+	addRoute(rt route, mws []Middleware) *Route
+}
+
+// HandleTyped registers fn adapted with Typed on r for method and path, and
+// records In and Out as the route Input and Output listed by Routes:
+//
+//	cafe.HandleTyped(&app, http.MethodGet, "/items/{id}", getItem)
+func HandleTyped[In, Out any](r Registrar, method, path string, fn func(ctx context.Context, in In) (Out, error), mws ...Middleware) *Route {
+	return r.addRoute(route{
+		path:     path,
+		method:   method,
+		handler:  Typed(fn),
+		funcName: funcName(fn),
+		input:    reflect.TypeFor[In](),
+		output:   reflect.TypeFor[Out](),
+	}, mws)
+}
+
+// bindTyped binds a struct with Bind and decodes anything else from the
+// body.
+func bindTyped(r *http.Request, dst any) error {
+	if reflect.TypeOf(dst).Elem().Kind() == reflect.Struct {
+		return Bind(r, dst)
+	}
+	errs := []FieldError{}
+	if _, err := bindBody(r, dst, &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+// typedSignature returns In and Out when t is the type of a function Typed
+// adapts, and nil otherwise.
+func typedSignature(t reflect.Type) (reflect.Type, reflect.Type) {
+	if t == nil || t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 2 ||
+		t.In(0) != reflect.TypeFor[context.Context]() || t.Out(1) != reflect.TypeFor[error]() {
+		return nil, nil
+	}
+	return t.In(1), t.Out(0)
+}
+
+/*** Negotiation ***/
+
+// writeNegotiated encodes out with the media type the request accepts best,
+// or returns a 406 HTTPError when it accepts none of them.
+func writeNegotiated(w http.ResponseWriter, r *http.Request, out any) error {
+	t := reflect.TypeOf(out)
+	if t != nil && t.Kind() == reflect.Struct && t.NumField() == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	offers := []string{"application/json"}
+	text, isText := textOf(out)
+	if isText {
+		offers = append(offers, "text/plain")
+	}
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t != nil && t.Kind() == reflect.Struct {
+		offers = append(offers, "application/xml")
+	}
+
+	var body []byte
+	var err error
+	mediaType := negotiate(r.Header.Get("Accept"), offers)
+	switch mediaType {
+	case "application/json":
+		body, err = json.Marshal(out)
+		body = append(body, '\n')
+	case "text/plain":
+		body, err = text, nil
+	case "application/xml":
+		body, err = xml.Marshal(out)
+	default:
+		return &HTTPError{
+			Status:  http.StatusNotAcceptable,
+			Message: "acceptable media types are " + strings.Join(offers, ", "),
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	return err
+}
+
+// textOf returns out as plain text when it is a string or a TextMarshaler.
+func textOf(out any) ([]byte, bool) {
+	switch v := out.(type) {
+	case string:
+		return []byte(v), true
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		return text, err == nil
+	}
+	return nil, false
+}
+
+// negotiate returns the offer the Accept header prefers, the first one when
+// the header is empty, and "" when none is acceptable. An offer takes the
+// quality of the most specific media range matching it.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		quality, specificity := 0.0, -1
+		for _, part := range strings.Split(accept, ",") {
+			mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			s := rangeSpecificity(mediaRange, offer)
+			if s <= specificity {
+				continue
+			}
+			quality, specificity = 1, s
+			if q, ok := params["q"]; ok {
+				if quality, err = strconv.ParseFloat(q, 64); err != nil {
+					quality = 0
+				}
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best
+}
+
+// rangeSpecificity returns how specifically mediaRange matches mediaType:
+// 2 for the same type, 1 for type/*, 0 for */* and -1 for no match.
+func rangeSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,85 @@
+package cafe
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var wildcard = regexp.MustCompile(`\{([^}]*)\}`)
+
+/*** Reverse routing ***/
+
+// URL builds the full path of the route registered under name, including
+// every router mount prefix. params are name/value pairs for the path
+// wildcards; values are escaped, and a missing wildcard is an error.
+func (a *App) URL(name string, params ...string) (string, error) {
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("cafe: URL %q: odd number of parameters", name)
+	}
+	path, ok := a.routePath(name)
+	if !ok {
+		return "", fmt.Errorf("cafe: URL %q: no route with that name", name)
+	}
+
+	values := map[string]string{}
+	for i := 0; i < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+
+	var missing []string
+	built := wildcard.ReplaceAllStringFunc(path, func(w string) string {
+		param := strings.Trim(w, "{}")
+		if param == "$" {
+			return ""
+		}
+		param, multi := strings.CutSuffix(param, "...")
+		value, ok := values[param]
+		if !ok {
+			missing = append(missing, param)
+			return w
+		}
+		if !multi {
+			return url.PathEscape(value)
+		}
+		segments := strings.Split(value, "/")
+		for i, s := range segments {
+			segments[i] = url.PathEscape(s)
+		}
+		return strings.Join(segments, "/")
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("cafe: URL %q: missing parameters %s", name, strings.Join(missing, ", "))
+	}
+	return built, nil
+}
+
+// routePath returns the full path of the route named name, from the route
+// table when the app is serving and from the registered routes otherwise,
+// without building the table early.
+func (a *App) routePath(name string) (string, bool) {
+	if t := a.handler.Load(); t != nil {
+		path, ok := t.names[name]
+		return path, ok
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	path, ok := routeNames(a.getRoutes())[name]
+	return path, ok
+}
+
+// routeNames maps every route name to the full path of the first route
+// registered under it.
+func routeNames(routes []route) map[string]string {
+	names := map[string]string{}
+	for _, rt := range routes {
+		if rt.name == "" {
+			continue
+		}
+		if _, ok := names[rt.name]; !ok {
+			names[rt.name] = rt.path
+		}
+	}
+	return names
+}
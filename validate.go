@@ -0,0 +1,177 @@
+package cafe
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"slices"
+)
+
+/*** Definitions ***/
+
+// RouteError reports a registration that cafe can't serve: a duplicate
+// route or router, or a pattern ServeMux considers in conflict with another
+// once mount prefixes are joined.
+type RouteError struct {
+	Pattern     string
+	Source      string
+	Reason      string
+	Other       string
+	OtherSource string
+}
+
+func (e *RouteError) Error() string {
+	if e.Other == "" {
+		return fmt.Sprintf("cafe: %s (%s) %s", e.Pattern, e.Source, e.Reason)
+	}
+	return fmt.Sprintf("cafe: %s (%s) %s %s (%s)", e.Pattern, e.Source, e.Reason, e.Other, e.OtherSource)
+}
+
+// muxEntry is a single pattern registration on the underlying ServeMux.
+// Derived entries (OPTIONS/405 and not-found fallbacks) are generated by
+// cafe, so their conflicts are not reported.
+type muxEntry struct {
+	pattern string
+	handler http.HandlerFunc
+	source  string
+	derived bool
+}
+
+/*** Validation ***/
+
+// Validate reports every duplicate route or router and every conflicting
+// pattern, each with the file:line it was registered at. Listen and
+// ListenContext refuse to start when it fails.
+func (a *App) Validate() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	errs := slices.Clone(a.errs)
+	for _, mr := range a.routers {
+		errs = append(errs, mr.router.getErrors()...)
+	}
+	errs = append(errs, unscopedNotFound(a.routers)...)
+	for _, h := range a.hosts {
+		errs = append(errs, h.mount.router.getErrors()...)
+	}
+	errs = append(errs, a.register(a.settings.newMatcher(), a.muxEntries(""))...)
+	for _, h := range a.hosts {
+		errs = append(errs, a.register(a.settings.newMatcher(), a.muxEntries(h.host))...)
+	}
+	errs = append(errs, a.nameErrors(a.getRoutes())...)
+	return errors.Join(errs...)
+}
+
+// nameErrors reports route names given to more than one registration.
+// Routes registered together, like those of Any, share their name.
+func (a *App) nameErrors(routes []route) []error {
+	errs := []error{}
+	first := map[string]route{}
+	for _, rt := range routes {
+		if rt.name == "" {
+			continue
+		}
+		other, ok := first[rt.name]
+		if !ok {
+			first[rt.name] = rt
+			continue
+		}
+		if other.meta != rt.meta {
+			errs = append(errs, &RouteError{
+				Pattern:     rt.pattern(a.settings),
+				Source:      rt.source,
+				Reason:      fmt.Sprintf("reuses name %q of", rt.name),
+				Other:       other.pattern(a.settings),
+				OtherSource: other.source,
+			})
+		}
+	}
+	return errs
+}
+
+// unscopedNotFound reports the not-found handlers of routers mounted without
+// a prefix: their subtree is their parent's, which already has a handler.
+func unscopedNotFound(routers []mountedRouter) []error {
+	errs := []error{}
+	for _, mr := range routers {
+		if mr.path != "" {
+			continue
+		}
+		if source := mr.router.notFoundSource(); source != "" {
+			errs = append(errs, &RouteError{
+				Pattern: "NotFound",
+				Source:  source,
+				Reason:  "is set on a router mounted without a prefix",
+			})
+		}
+	}
+	return errs
+}
+
+// register adds entries to mux in order, skipping the ones the Matcher
+// rejects instead of panicking, and returns a RouteError for each rejected
+// route.
+func (a *App) register(mux Matcher, entries []muxEntry) []error {
+	errs := []error{}
+	registered := []muxEntry{}
+	for _, e := range entries {
+		if err := tryHandle(mux, e.pattern, e.handler); err != nil {
+			if !e.derived {
+				errs = append(errs, a.conflictError(e, registered, err))
+			}
+			continue
+		}
+		registered = append(registered, e)
+	}
+	return errs
+}
+
+func (a *App) conflictError(e muxEntry, registered []muxEntry, cause error) error {
+	for _, other := range registered {
+		mux := a.settings.newMatcher()
+		mux.Handle(other.pattern, other.handler)
+		if tryHandle(mux, e.pattern, e.handler) != nil {
+			return &RouteError{
+				Pattern:     e.pattern,
+				Source:      e.source,
+				Reason:      "conflicts with",
+				Other:       other.pattern,
+				OtherSource: other.source,
+			}
+		}
+	}
+	return &RouteError{
+		Pattern: e.pattern,
+		Source:  e.source,
+		Reason:  "is invalid: " + cause.Error(),
+	}
+}
+
+// tryHandle registers pattern on mux, turning a Matcher panic into an error.
+func tryHandle(mux Matcher, pattern string, handler http.Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	mux.Handle(pattern, handler)
+	return nil
+}
+
+/*** Helpers ***/
+
+// callerSite returns the file:line skip frames above its caller.
+func callerSite(skip int) string {
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+func appendError(errs []error, err error) []error {
+	if err == nil {
+		return errs
+	}
+	return append(errs, err)
+}